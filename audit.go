@@ -0,0 +1,205 @@
+package multikv
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// AuditRecord is a mutation of a key. Checksums are the hex-encoded SHA-256 of values, empty when
+// there was no value before (OldChecksum) or after (NewChecksum).
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Operation is Put, Delete, Copy or Move
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	// Target is the destination of a Copy or Move. Their checksums are the ones of the target.
+	Target string `json:"target,omitempty"`
+	// Principal is the principal in the context of the operation, if any (see auth.WithPrincipal)
+	Principal   string `json:"principal,omitempty"`
+	OldChecksum string `json:"oldChecksum,omitempty"`
+	NewChecksum string `json:"newChecksum,omitempty"`
+}
+
+// AuditSink stores audit records. Sinks that can read them back also implement AuditHistory.
+type AuditSink interface {
+	Record(record AuditRecord) error
+}
+
+type AuditHistory interface {
+	// History returns the records of path, including Copies and Moves to it, oldest first
+	History(path string) ([]AuditRecord, error)
+}
+
+// WriterAuditSink writes records to an io.Writer as JSON lines
+type WriterAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{enc: json.NewEncoder(w)}
+}
+
+func (s *WriterAuditSink) Record(record AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(record)
+}
+
+// BackendAuditSink stores every record in its own file under prefix in a backend, which is never
+// rewritten. Records of a key are stored together, under the path of the key, so that History only
+// lists a single directory. Paths are percent-encoded, to store the records of keys of any KeyEncoding.
+type BackendAuditSink struct {
+	backend backends.KvBackend
+	prefix  string
+}
+
+// NewBackendAuditSink stores records under prefix in backend. When it is the backend of the KV
+// being audited, prefix must not be used by keys, and it is listed like a prefix by List.
+func NewBackendAuditSink(backend backends.KvBackend, prefix string) *BackendAuditSink {
+	return &BackendAuditSink{backend: backend, prefix: prefix}
+}
+
+func (s *BackendAuditSink) Record(record AuditRecord) error {
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return fmt.Errorf("failed to generate audit record (%s)", err)
+	}
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if err != nil {
+		return fmt.Errorf("failed to generate audit record id (%s)", err)
+	}
+	// Zero-padded so that names sort by time
+	name := fmt.Sprintf("%020d-%s.json", record.Time.UnixNano(), hex.EncodeToString(id))
+	paths := []string{record.Path}
+	if record.Target != "" {
+		paths = append(paths, record.Target)
+	}
+	for _, path := range paths {
+		err = s.backend.WriteFile(joinKey(s.prefix, encodeKey(PercentEncoding, path), name), data)
+		if err != nil {
+			return fmt.Errorf("failed to write audit record (%s)", err)
+		}
+	}
+	return nil
+}
+
+func (s *BackendAuditSink) History(path string) ([]AuditRecord, error) {
+	dir := joinKey(s.prefix, encodeKey(PercentEncoding, path))
+	names, err := s.backend.ListDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit records (%s)", err)
+	}
+	var records []AuditRecord
+	for _, name := range names {
+		// Directories hold the records of the keys under path
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		data, err := s.backend.ReadFile(joinKey(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit record %s (%s)", name, err)
+		}
+		record := AuditRecord{}
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse audit record %s (%s)", name, err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// History returns the audit records of path, oldest first, with the paths of records from the root of
+// the store. The Audit sink of kv must implement
+// AuditHistory, otherwise backends.ErrNotSupported is returned.
+func (kv *KV) History(path string) ([]AuditRecord, error) {
+	return kv.HistoryContext(context.Background(), path)
+}
+
+func (kv *KV) HistoryContext(ctx context.Context, path string) (_ []AuditRecord, err error) {
+	_, op := kv.begin(ctx, "History", path)
+	defer func() { op.end(0, err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.authorize(ctx, auth.Read, "History", path)
+	if err != nil {
+		return nil, err
+	}
+	history, ok := kv.Audit.(AuditHistory)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	return history.History(joinKey(kv.prefix, path))
+}
+
+// audit records a mutation made by the principal in ctx, when kv has an Audit sink
+func (kv *KV) audit(ctx context.Context, record AuditRecord) error {
+	if kv.Audit == nil {
+		return nil
+	}
+	record.Time = time.Now()
+	record.Path = joinKey(kv.prefix, record.Path)
+	if record.Target != "" {
+		record.Target = joinKey(kv.prefix, record.Target)
+	}
+	record.Principal, _ = auth.PrincipalFromContext(ctx)
+	err := kv.Audit.Record(record)
+	if err != nil {
+		return fmt.Errorf("failed to record audit (%s)", err)
+	}
+	return nil
+}
+
+// auditChecksum returns the checksum of the value of key when kv has an Audit sink
+func (kv *KV) auditChecksum(backend backends.KvBackend, key string) (string, error) {
+	if kv.Audit == nil {
+		return "", nil
+	}
+	sum, err := storedChecksum(backend, kv.storedPath(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s for audit (%s)", key, err)
+	}
+	return sum, nil
+}
+
+func checksum(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// storedChecksum returns the checksum of the value of the key stored at dir, or an empty string if
+// there is no such key
+func storedChecksum(backend backends.KvBackend, dir string) (string, error) {
+	dataPath := joinKey(dir, "data")
+	found, err := backend.Exist(dataPath)
+	if err != nil || !found {
+		return "", err
+	}
+	data, err := backend.ReadFile(dataPath)
+	if err != nil {
+		return "", err
+	}
+	value, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode data file of %s (%s)", dir, err)
+	}
+	return checksum(value), nil
+}
@@ -0,0 +1,177 @@
+package multikv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+func TestAudit(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	auditKV, cleanupAudit := newTestKV(t)
+	defer cleanupAudit()
+	kv.Audit = NewBackendAuditSink(auditKV.Backend, "audit")
+	alice := auth.WithPrincipal(context.Background(), "alice")
+
+	err := kv.PutContext(alice, "test/a", []byte("v1"))
+	if err != nil {
+		t.Fatalf("TestAudit: Put should have succeeded (%s)", err)
+	}
+	err = kv.Put("test/a", []byte("v2"))
+	if err != nil {
+		t.Fatalf("TestAudit: Put should have succeeded (%s)", err)
+	}
+	err = kv.MoveContext(alice, "test/a", "test/b")
+	if err != nil {
+		t.Fatalf("TestAudit: Move should have succeeded (%s)", err)
+	}
+	err = kv.DeleteContext(alice, "test")
+	if err != nil {
+		t.Fatalf("TestAudit: Delete should have succeeded (%s)", err)
+	}
+
+	history, err := kv.History("test/a")
+	if err != nil {
+		t.Fatalf("TestAudit: History should have succeeded (%s)", err)
+	}
+	expected := []AuditRecord{
+		{Operation: "Put", Path: "test/a", Principal: "alice", NewChecksum: checksum([]byte("v1"))},
+		{Operation: "Put", Path: "test/a", OldChecksum: checksum([]byte("v1")), NewChecksum: checksum([]byte("v2"))},
+		{Operation: "Move", Path: "test/a", Target: "test/b", Principal: "alice", NewChecksum: checksum([]byte("v2"))},
+	}
+	checkHistory(t, history, expected)
+
+	history, err = kv.History("test/b")
+	if err != nil {
+		t.Fatalf("TestAudit: History should have succeeded (%s)", err)
+	}
+	expected = []AuditRecord{
+		{Operation: "Move", Path: "test/a", Target: "test/b", Principal: "alice", NewChecksum: checksum([]byte("v2"))},
+		{Operation: "Delete", Path: "test/b", Principal: "alice", OldChecksum: checksum([]byte("v2"))},
+	}
+	checkHistory(t, history, expected)
+}
+
+func TestAudit_RestoreImportSync(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	sink := &memoryAuditSink{}
+	kv.Audit = sink
+	for _, put := range []struct{ key, value string }{{"a", "v1"}, {"b", "v1"}} {
+		err := kv.Put(put.key, []byte(put.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := kv.Snapshot("", "snap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, put := range []struct{ key, value string }{{"a", "v2"}, {"c", "v1"}} {
+		err = kv.Put(put.key, []byte(put.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.records = nil
+	err = kv.RestoreSnapshot("snap")
+	if err != nil {
+		t.Fatalf("TestAudit: RestoreSnapshot should have succeeded (%s)", err)
+	}
+	checkRecords(t, sink.records, []AuditRecord{
+		{Operation: "Delete", Path: "c", OldChecksum: checksum([]byte("v1"))},
+		{Operation: "Put", Path: "a", OldChecksum: checksum([]byte("v2")), NewChecksum: checksum([]byte("v1"))},
+		{Operation: "Put", Path: "b", OldChecksum: checksum([]byte("v1")), NewChecksum: checksum([]byte("v1"))},
+	})
+
+	other, cleanupOther := newTestKV(t)
+	defer cleanupOther()
+	otherSink := &memoryAuditSink{}
+	other.Audit = otherSink
+	var buf bytes.Buffer
+	err = kv.Export("a", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Import(&buf, ConflictOverwrite)
+	if err != nil {
+		t.Fatalf("TestAudit: Import should have succeeded (%s)", err)
+	}
+	checkRecords(t, otherSink.records, []AuditRecord{{Operation: "Put", Path: "a", NewChecksum: checksum([]byte("v1"))}})
+
+	err = other.Put("d", []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSink.records = nil
+	_, err = Sync(kv, other, SyncOptions{Prefix: "b", Delete: true})
+	if err != nil {
+		t.Fatalf("TestAudit: Sync should have succeeded (%s)", err)
+	}
+	_, err = Sync(kv, other, SyncOptions{Prefix: "d", Delete: true})
+	if err != nil {
+		t.Fatalf("TestAudit: Sync should have succeeded (%s)", err)
+	}
+	checkRecords(t, otherSink.records, []AuditRecord{
+		{Operation: "Put", Path: "b", NewChecksum: checksum([]byte("v1"))},
+		{Operation: "Delete", Path: "d", OldChecksum: checksum([]byte("v1"))},
+	})
+}
+
+// checkRecords compares records to expected, ignoring their time
+func checkRecords(t *testing.T, records []AuditRecord, expected []AuditRecord) {
+	t.Helper()
+	for i := range records {
+		records[i].Time = time.Time{}
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("TestAudit: expected records %+v, got %+v", expected, records)
+	}
+}
+
+func checkHistory(t *testing.T, history []AuditRecord, expected []AuditRecord) {
+	t.Helper()
+	if len(history) != len(expected) {
+		t.Fatalf("TestAudit: expected %d records, got %+v", len(expected), history)
+	}
+	for i, record := range history {
+		if record.Time.IsZero() {
+			t.Errorf("TestAudit: record %d has no time", i)
+		}
+		record.Time = expected[i].Time
+		if record != expected[i] {
+			t.Errorf("TestAudit: record %d did not match. Expected: %+v; Found: %+v", i, expected[i], record)
+		}
+	}
+}
+
+func TestWriterAuditSink(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	var buf bytes.Buffer
+	kv.Audit = NewWriterAuditSink(&buf)
+	err := kv.PutReader("test/a", bytes.NewReader([]byte("streamed")))
+	if err != nil {
+		t.Fatalf("TestWriterAuditSink: PutReader should have succeeded (%s)", err)
+	}
+	record := AuditRecord{}
+	err = json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("TestWriterAuditSink: expected a JSON record, got '%s'", buf.String())
+	}
+	if record.Operation != "Put" || record.Path != "test/a" || record.NewChecksum != checksum([]byte("streamed")) {
+		t.Errorf("TestWriterAuditSink: unexpected record %+v", record)
+	}
+
+	_, err = kv.History("test/a")
+	if err != backends.ErrNotSupported {
+		t.Errorf("TestWriterAuditSink: History should not be supported, got %v", err)
+	}
+}
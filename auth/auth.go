@@ -0,0 +1,74 @@
+// Package auth authenticates the clients of the served APIs and authorizes what they can do with a
+// Policy. Authenticators turn the credentials of a request (a bearer token or a TLS client
+// certificate) into a principal, which is carried in the context of the operations it makes:
+//
+//	principal, err := auth.StaticTokens{"alice": "s3cr3t"}.Authenticate(auth.CredentialsFromRequest(r))
+//	ctx := auth.WithPrincipal(r.Context(), principal)
+//	value, err := kv.GetContext(ctx, "secrets/db") // checked against kv.Authorizer
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/marcelocarlos/multikv/internal/logfmt"
+)
+
+type Permission string
+
+const (
+	// Read allows reading the value and info of keys
+	Read Permission = "read"
+	// Write allows writing and deleting keys
+	Write Permission = "write"
+	// List allows listing, walking and watching prefixes
+	List Permission = "list"
+)
+
+var (
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, if any
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
+
+// Authorizer checks operations against a Policy, and logs every decision when Log is set, e.g.:
+//
+//	principal=alice op=Get permission=read path=secrets/db allowed=true
+//	principal=bob op=Put permission=write path=secrets/db allowed=false
+type Authorizer struct {
+	Policy *Policy
+	Log    *log.Logger
+}
+
+// Authorize returns an error wrapping ErrPermissionDenied unless the principal in ctx has perm on
+// path. Contexts without a principal are anonymous, and only get the permissions granted to "*".
+func (a *Authorizer) Authorize(ctx context.Context, perm Permission, op string, path string) error {
+	principal, _ := PrincipalFromContext(ctx)
+	allowed := a.Policy != nil && a.Policy.Allows(principal, perm, path)
+	if a.Log != nil {
+		a.Log.Print("principal=" + logfmt.Value(principal) + " op=" + op + " permission=" + string(perm) +
+			" path=" + logfmt.Value(path) + " allowed=" + strconv.FormatBool(allowed))
+	}
+	if !allowed {
+		if principal == "" {
+			principal = "anonymous"
+		}
+		return fmt.Errorf("%w: %s cannot %s %s", ErrPermissionDenied, principal, perm, path)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestAuthorizer(t *testing.T) {
+	var logs bytes.Buffer
+	authorizer := &Authorizer{
+		Policy: &Policy{Rules: []Rule{{Principals: []string{"alice"}, Prefix: "secrets", Permissions: []Permission{Read}}}},
+		Log:    log.New(&logs, "", 0),
+	}
+	err := authorizer.Authorize(WithPrincipal(context.Background(), "alice"), Read, "Get", "secrets/db")
+	if err != nil {
+		t.Errorf("TestAuthorizer: alice should be able to read secrets (%s)", err)
+	}
+	err = authorizer.Authorize(context.Background(), Read, "Get", "secrets/my db")
+	if !errors.Is(err, ErrPermissionDenied) || err.Error() != "permission denied: anonymous cannot read secrets/my db" {
+		t.Errorf("TestAuthorizer: anonymous principals should have been denied, got %v", err)
+	}
+	expected := "principal=alice op=Get permission=read path=secrets/db allowed=true\n" +
+		`principal="" op=Get permission=read path="secrets/my db" allowed=false` + "\n"
+	if logs.String() != expected {
+		t.Errorf("TestAuthorizer: expected logs:\n%s\ngot:\n%s", expected, logs.String())
+	}
+
+	// Without a policy, everything is denied
+	err = (&Authorizer{}).Authorize(WithPrincipal(context.Background(), "alice"), Read, "Get", "secrets/db")
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("TestAuthorizer: expected a denial without a policy, got %v", err)
+	}
+}
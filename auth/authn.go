@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Credentials are what a client presented to authenticate
+type Credentials struct {
+	// Token is the bearer token of the request, if any
+	Token string
+	// Certificates is the verified certificate chain of the client, if it connected with TLS and
+	// presented a certificate
+	Certificates []*x509.Certificate
+}
+
+// Authenticator returns the principal credentials belong to, or an error wrapping
+// ErrUnauthenticated if they don't prove any
+type Authenticator interface {
+	Authenticate(creds Credentials) (string, error)
+}
+
+// StaticTokens maps principals to their bearer token
+type StaticTokens map[string]string
+
+// LoadTokens reads tokens from a JSON file mapping principals to their token, e.g.:
+//
+//	{"alice": "s3cr3t", "deployer": "an0ther-s3cr3t"}
+func LoadTokens(path string) (StaticTokens, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens (%s)", err)
+	}
+	tokens := StaticTokens{}
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tokens (%s)", err)
+	}
+	for principal, token := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("empty token for %s", principal)
+		}
+	}
+	return tokens, nil
+}
+
+func (t StaticTokens) Authenticate(creds Credentials) (string, error) {
+	if creds.Token == "" {
+		return "", fmt.Errorf("%w: no bearer token", ErrUnauthenticated)
+	}
+	for principal, token := range t {
+		if subtle.ConstantTimeCompare([]byte(creds.Token), []byte(token)) == 1 {
+			return principal, nil
+		}
+	}
+	return "", fmt.Errorf("%w: invalid bearer token", ErrUnauthenticated)
+}
+
+// ClientCertificates authenticates clients by the common name of their TLS certificate. The
+// certificate must have been verified by the server, e.g. with tls.RequireAndVerifyClientCert.
+type ClientCertificates struct{}
+
+func (ClientCertificates) Authenticate(creds Credentials) (string, error) {
+	if len(creds.Certificates) == 0 || creds.Certificates[0].Subject.CommonName == "" {
+		return "", fmt.Errorf("%w: no client certificate", ErrUnauthenticated)
+	}
+	return creds.Certificates[0].Subject.CommonName, nil
+}
+
+// Authenticators tries each authenticator in turn, and returns the principal of the first one that
+// succeeds
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(creds Credentials) (string, error) {
+	var errs []string
+	for _, authenticator := range a {
+		principal, err := authenticator.Authenticate(creds)
+		if err == nil {
+			return principal, nil
+		}
+		errs = append(errs, strings.TrimPrefix(err.Error(), ErrUnauthenticated.Error()+": "))
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnauthenticated, strings.Join(errs, ", "))
+}
+
+// CredentialsFromRequest returns the bearer token of the Authorization header of r and its verified
+// client certificate
+func CredentialsFromRequest(r *http.Request) Credentials {
+	creds := Credentials{Token: bearerToken(r.Header.Get("Authorization"))}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		creds.Certificates = r.TLS.VerifiedChains[0]
+	}
+	return creds
+}
+
+func bearerToken(header string) string {
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
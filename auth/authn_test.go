@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"alice": "s3cr3t", "bob": "an0ther"}
+	principal, err := tokens.Authenticate(Credentials{Token: "an0ther"})
+	if err != nil || principal != "bob" {
+		t.Errorf("TestStaticTokens: expected bob, got %s (%v)", principal, err)
+	}
+	for _, token := range []string{"", "wrong", "s3cr3"} {
+		_, err = tokens.Authenticate(Credentials{Token: token})
+		if !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("TestStaticTokens: token '%s' should have been rejected, got %v", token, err)
+		}
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}
+	principal, err := ClientCertificates{}.Authenticate(Credentials{Certificates: []*x509.Certificate{cert}})
+	if err != nil || principal != "deployer" {
+		t.Errorf("TestClientCertificates: expected deployer, got %s (%v)", principal, err)
+	}
+	_, err = ClientCertificates{}.Authenticate(Credentials{Token: "s3cr3t"})
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("TestClientCertificates: credentials without a certificate should have been rejected, got %v", err)
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	authenticator := Authenticators{StaticTokens{"alice": "s3cr3t"}, ClientCertificates{}}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}
+	principal, err := authenticator.Authenticate(Credentials{Certificates: []*x509.Certificate{cert}})
+	if err != nil || principal != "deployer" {
+		t.Errorf("TestAuthenticators: expected deployer, got %s (%v)", principal, err)
+	}
+	_, err = authenticator.Authenticate(Credentials{})
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("TestAuthenticators: empty credentials should have been rejected, got %v", err)
+	}
+}
+
+func TestCredentialsFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/keys/a", nil)
+	r.Header.Set("Authorization", "bearer s3cr3t")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}
+	// Only verified certificates are used
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	creds := CredentialsFromRequest(r)
+	if creds.Token != "s3cr3t" || len(creds.Certificates) != 0 {
+		t.Errorf("TestCredentialsFromRequest: unexpected credentials %+v", creds)
+	}
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	r.Header.Set("Authorization", "Basic YWxpY2U6czNjcjN0")
+	creds = CredentialsFromRequest(r)
+	if creds.Token != "" || len(creds.Certificates) != 1 {
+		t.Errorf("TestCredentialsFromRequest: unexpected credentials %+v", creds)
+	}
+}
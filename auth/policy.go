@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Rule grants permissions on every path under Prefix (an empty prefix being the whole store) to
+// Principals, where "*" stands for any principal
+type Rule struct {
+	Principals  []string     `json:"principals"`
+	Prefix      string       `json:"prefix"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Policy is a list of rules. Permissions are denied unless a rule grants them.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadPolicy reads a policy from a JSON file, e.g.:
+//
+//	{"rules": [
+//	  {"principals": ["*"], "prefix": "public", "permissions": ["read", "list"]},
+//	  {"principals": ["deployer"], "prefix": "secrets", "permissions": ["read", "write", "list"]}
+//	]}
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy (%s)", err)
+	}
+	policy := &Policy{}
+	err = json.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy (%s)", err)
+	}
+	for _, rule := range policy.Rules {
+		for _, perm := range rule.Permissions {
+			if perm != Read && perm != Write && perm != List {
+				return nil, fmt.Errorf("unknown permission %s for prefix %s", perm, rule.Prefix)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Allows tells if a rule grants perm on path to principal
+func (p *Policy) Allows(principal string, perm Permission, path string) bool {
+	path = canonicalPath(path)
+	for _, rule := range p.Rules {
+		if rule.matches(principal, perm) && underPrefix(path, canonicalPath(rule.Prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(principal string, perm Permission) bool {
+	hasPerm := false
+	for _, p := range r.Permissions {
+		hasPerm = hasPerm || p == perm
+	}
+	if !hasPerm {
+		return false
+	}
+	for _, p := range r.Principals {
+		if p == "*" || (p == principal && principal != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalPath cleans path and uses forward slashes, without leading or trailing ones
+func canonicalPath(path string) string {
+	path = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+	if path == "." {
+		return ""
+	}
+	return path
+}
+
+// underPrefix tells if path is prefix or under it, "secrets" being a prefix of "secrets/db" but not of
+// "secrets-old"
+func underPrefix(path string, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy_Allows(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{Principals: []string{"*"}, Prefix: "public/", Permissions: []Permission{Read, List}},
+		{Principals: []string{"alice", "bob"}, Prefix: "secrets", Permissions: []Permission{Read}},
+		{Principals: []string{"admin"}, Prefix: "", Permissions: []Permission{Read, Write, List}},
+	}}
+	tests := []struct {
+		principal string
+		perm      Permission
+		path      string
+		expected  bool
+	}{
+		{"", Read, "public/a", true},
+		{"carol", List, "public", true},
+		{"carol", Write, "public/a", false},
+		{"alice", Read, "secrets/db", true},
+		{"alice", Read, "secrets", true},
+		{"alice", Read, "secrets-old/db", false},
+		{"alice", Read, "public/../secrets/db", true},
+		{"carol", Read, "public/../secrets/db", false},
+		{"alice", Write, "secrets/db", false},
+		{"admin", Write, "anything/at/all", true},
+		{"", Read, "secrets/db", false},
+	}
+	for _, test := range tests {
+		allowed := policy.Allows(test.principal, test.perm, test.path)
+		if allowed != test.expected {
+			t.Errorf("TestPolicy_Allows: %s %s %s: expected %v, got %v", test.principal, test.perm, test.path, test.expected, allowed)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	err = ioutil.WriteFile(path, []byte(`{"rules": [{"principals": ["alice"], "prefix": "secrets", "permissions": ["read", "list"]}]}`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("TestLoadPolicy: should have succeeded (%s)", err)
+	}
+	if !policy.Allows("alice", List, "secrets/a") || policy.Allows("alice", Write, "secrets/a") {
+		t.Errorf("TestLoadPolicy: unexpected rules %+v", policy.Rules)
+	}
+
+	err = ioutil.WriteFile(path, []byte(`{"rules": [{"principals": ["alice"], "prefix": "secrets", "permissions": ["admin"]}]}`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	_, err = LoadPolicy(path)
+	if err == nil {
+		t.Errorf("TestLoadPolicy: an unknown permission should have failed")
+	}
+}
@@ -2,9 +2,22 @@ package backends
 
 type KvBackend interface {
 	Exist(path string) (bool, error)
+	// ListDir returns the names (not the full paths) of the entries directly under path, sorted, with
+	// a trailing "/" for directories. A path that doesn't exist is an empty directory.
 	ListDir(path string) ([]string, error)
 	DeleteDir(path string) error
 	DeleteFile(path string) error
 	ReadFile(path string) ([]byte, error)
 	WriteFile(path string, data []byte) error
 }
+
+// Middleware wraps a backend to add behaviour around its operations
+type Middleware func(KvBackend) KvBackend
+
+// Chain wraps backend with middlewares, the first one being the outermost
+func Chain(backend KvBackend, middlewares ...Middleware) KvBackend {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		backend = middlewares[i](backend)
+	}
+	return backend
+}
@@ -0,0 +1,185 @@
+// Package backendtest provides a test suite that checks that a backends.KvBackend implementation
+// behaves like the backends shipped with multikv. Third-party backends can run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+//			return newEmptyBackend(t)
+//		})
+//	}
+package backendtest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// Factory returns a new, empty backend. It is called once per test case, use t.Cleanup to release
+// the resources it holds.
+type Factory func(t *testing.T) backends.KvBackend
+
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, backend backends.KvBackend)
+	}{
+		{"WriteFile", testWriteFile},
+		{"WriteFile_Nested", testWriteFileNested},
+		{"WriteFile_Overwrite", testWriteFileOverwrite},
+		{"WriteFile_Empty", testWriteFileEmpty},
+		{"ReadFile_Missing", testReadFileMissing},
+		{"Exist", testExist},
+		{"DeleteFile", testDeleteFile},
+		{"DeleteFile_Missing", testDeleteFileMissing},
+		{"DeleteDir", testDeleteDir},
+		{"DeleteDir_Missing", testDeleteDirMissing},
+		{"ListDir", testListDir},
+		{"ListDir_Missing", testListDirMissing},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, factory(t))
+		})
+	}
+}
+
+func testWriteFile(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "file", "test")
+	expectContents(t, backend, "file", "test")
+}
+
+func testWriteFileNested(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "dir/sub/file", "test")
+	expectContents(t, backend, "dir/sub/file", "test")
+}
+
+func testWriteFileOverwrite(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "file", "a longer value")
+	writeFile(t, backend, "file", "short")
+	expectContents(t, backend, "file", "short")
+}
+
+func testWriteFileEmpty(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "file", "")
+	expectContents(t, backend, "file", "")
+}
+
+func testReadFileMissing(t *testing.T, backend backends.KvBackend) {
+	_, err := backend.ReadFile("missing")
+	if err == nil {
+		t.Errorf("ReadFile: reading a missing file should have failed")
+	}
+}
+
+func testExist(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "dir/file", "test")
+	expectExist(t, backend, "dir/file", true)
+	expectExist(t, backend, "missing", false)
+	expectExist(t, backend, "dir/missing", false)
+}
+
+func testDeleteFile(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "dir/file", "test")
+	writeFile(t, backend, "dir/other", "test")
+	err := backend.DeleteFile("dir/file")
+	if err != nil {
+		t.Errorf("DeleteFile: should not have failed (%s)", err)
+	}
+	expectExist(t, backend, "dir/file", false)
+	expectExist(t, backend, "dir/other", true)
+}
+
+func testDeleteFileMissing(t *testing.T, backend backends.KvBackend) {
+	err := backend.DeleteFile("missing")
+	if err == nil {
+		t.Errorf("DeleteFile: deleting a missing file should have failed")
+	}
+}
+
+func testDeleteDir(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "dir/file", "test")
+	writeFile(t, backend, "dir/sub/file", "test")
+	writeFile(t, backend, "dir-sibling/file", "test")
+	err := backend.DeleteDir("dir")
+	if err != nil {
+		t.Errorf("DeleteDir: should not have failed (%s)", err)
+	}
+	expectExist(t, backend, "dir/file", false)
+	expectExist(t, backend, "dir/sub/file", false)
+	// Only the directory itself is removed, not other paths sharing its name as a prefix
+	expectExist(t, backend, "dir-sibling/file", true)
+}
+
+func testDeleteDirMissing(t *testing.T, backend backends.KvBackend) {
+	err := backend.DeleteDir("missing")
+	if err != nil {
+		t.Errorf("DeleteDir: deleting a missing directory should not have failed (%s)", err)
+	}
+}
+
+func testListDir(t *testing.T, backend backends.KvBackend) {
+	writeFile(t, backend, "dir/a", "test")
+	writeFile(t, backend, "dir/b", "test")
+	writeFile(t, backend, "dir/sub/c", "test")
+	writeFile(t, backend, "other/d", "test")
+	files, err := backend.ListDir("dir")
+	if err != nil {
+		t.Fatalf("ListDir: should not have failed (%s)", err)
+	}
+	expected := []string{"a", "b", "sub/"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("ListDir: expected %v, got %v", expected, files)
+	}
+
+	files, err = backend.ListDir("")
+	if err != nil {
+		t.Fatalf("ListDir: listing the root should not have failed (%s)", err)
+	}
+	expected = []string{"dir/", "other/"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("ListDir: expected %v for the root, got %v", expected, files)
+	}
+}
+
+func testListDirMissing(t *testing.T, backend backends.KvBackend) {
+	files, err := backend.ListDir("missing")
+	if err != nil {
+		t.Errorf("ListDir: listing a missing directory should not have failed (%s)", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("ListDir: listing a missing directory should have returned nothing, got %v", files)
+	}
+}
+
+func writeFile(t *testing.T, backend backends.KvBackend, path string, contents string) {
+	t.Helper()
+	err := backend.WriteFile(path, []byte(contents))
+	if err != nil {
+		t.Fatalf("WriteFile: should have succeeded (%s)", err)
+	}
+}
+
+func expectContents(t *testing.T, backend backends.KvBackend, path string, contents string) {
+	t.Helper()
+	data, err := backend.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: should not have failed (%s)", err)
+	}
+	if !bytes.Equal(data, []byte(contents)) {
+		t.Errorf("ReadFile: stored value is different from original (expected '%s' got '%s')", contents, data)
+	}
+}
+
+func expectExist(t *testing.T, backend backends.KvBackend, path string, expected bool) {
+	t.Helper()
+	found, err := backend.Exist(path)
+	if err != nil {
+		t.Errorf("Exist: should not have failed for %s (%s)", path, err)
+	}
+	if found != expected {
+		t.Errorf("Exist: expected %t for %s, got %t", expected, path, found)
+	}
+}
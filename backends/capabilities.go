@@ -0,0 +1,154 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Backends only have to implement KvBackend. The interfaces below are optional: backends implement
+// the ones they can support efficiently, and callers detect them with a type assertion, checked
+// against CapabilitiesOf for backends that wrap another one (see Wrapper).
+
+var (
+	ErrNotSupported       = errors.New("operation not supported by the backend")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Copier is implemented by backends that can copy a file without downloading and uploading it again
+type Copier interface {
+	CopyFile(src string, dst string) error
+}
+
+// Mover is implemented by backends that can move a file without downloading and uploading it again
+type Mover interface {
+	MoveFile(src string, dst string) error
+}
+
+// ConditionalWriter is implemented by backends that can write a file only if it hasn't changed since
+// it was last read
+type ConditionalWriter interface {
+	// Version returns an opaque token identifying the current contents of path, or an empty string
+	// if path doesn't exist
+	Version(path string) (string, error)
+	// WriteFileIf writes data to path only if its version still matches version (an empty version
+	// meaning that path must not exist yet), and returns ErrPreconditionFailed otherwise
+	WriteFileIf(path string, data []byte, version string) error
+}
+
+// Streamer is implemented by backends that can read and write files without holding them in memory
+type Streamer interface {
+	OpenReader(path string) (io.ReadCloser, error)
+	// OpenWriter returns a writer whose contents are stored at path when it is closed
+	OpenWriter(path string) (io.WriteCloser, error)
+}
+
+// BatchDeleter is implemented by backends that can delete several files more efficiently than one at a time
+type BatchDeleter interface {
+	DeleteFiles(paths []string) error
+}
+
+// Pager is implemented by backends that can list a directory one page at a time
+type Pager interface {
+	// ListDirPage returns up to pageSize entries of path, named and sorted like ListDir, starting
+	// after cursor (an empty cursor meaning the first page). The returned cursor is empty after the
+	// last page.
+	ListDirPage(path string, pageSize int, cursor string) ([]string, string, error)
+}
+
+// PageNames returns the page of the sorted names that comes after cursor, using the last name of
+// each page as the cursor of the next one
+func PageNames(names []string, pageSize int, cursor string) ([]string, string) {
+	start := 0
+	if cursor != "" {
+		start = sort.SearchStrings(names, cursor)
+		if start < len(names) && names[start] == cursor {
+			start++
+		}
+	}
+	end := len(names)
+	if pageSize > 0 && start+pageSize < end {
+		end = start + pageSize
+	}
+	page := names[start:end]
+	if end == len(names) || len(page) == 0 {
+		return page, ""
+	}
+	return page, page[len(page)-1]
+}
+
+type EventType string
+
+const (
+	EventWrite  EventType = "write"
+	EventDelete EventType = "delete"
+)
+
+type Event struct {
+	Type EventType
+	Path string
+}
+
+// Watcher is implemented by backends that can report changes to the files under a path
+type Watcher interface {
+	// Watch sends an event for every file written or deleted under path until ctx is done, at which
+	// point the channel is closed
+	Watch(ctx context.Context, path string) (<-chan Event, error)
+}
+
+// Wrapper is implemented by backends that wrap another backend, like the retry backend and
+// middlewares. They implement every optional interface by forwarding it to the wrapped backend, and
+// fail with ErrNotSupported the calls it doesn't support, so the optional interfaces of a Wrapper must
+// be checked against CapabilitiesOf before use.
+type Wrapper interface {
+	Unwrap() KvBackend
+}
+
+// Reporter is implemented by backends that only know at runtime which of the optional interfaces they
+// implement are supported, like the remote backend, which depends on the backend it serves.
+// CapabilitiesOf only reports the interfaces they implement that Capabilities reports too.
+type Reporter interface {
+	Capabilities() Capabilities
+}
+
+type Capabilities struct {
+	Copy             bool
+	Move             bool
+	ConditionalWrite bool
+	Stream           bool
+	BatchDelete      bool
+	Watch            bool
+	Page             bool
+}
+
+// CapabilitiesOf reports which optional interfaces backend implements, or the backend it wraps for a
+// Wrapper
+func CapabilitiesOf(backend KvBackend) Capabilities {
+	for {
+		wrapper, ok := backend.(Wrapper)
+		if !ok {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+	c := Capabilities{}
+	_, c.Copy = backend.(Copier)
+	_, c.Move = backend.(Mover)
+	_, c.ConditionalWrite = backend.(ConditionalWriter)
+	_, c.Stream = backend.(Streamer)
+	_, c.BatchDelete = backend.(BatchDeleter)
+	_, c.Watch = backend.(Watcher)
+	_, c.Page = backend.(Pager)
+	if reporter, ok := backend.(Reporter); ok {
+		reported := reporter.Capabilities()
+		c.Copy = c.Copy && reported.Copy
+		c.Move = c.Move && reported.Move
+		c.ConditionalWrite = c.ConditionalWrite && reported.ConditionalWrite
+		c.Stream = c.Stream && reported.Stream
+		c.BatchDelete = c.BatchDelete && reported.BatchDelete
+		c.Watch = c.Watch && reported.Watch
+		c.Page = c.Page && reported.Page
+	}
+	return c
+}
@@ -2,31 +2,113 @@ package gcs
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/marcelocarlos/multikv/backends"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
+const (
+	defaultDeleteConcurrency = 16
+	defaultDeleteRetries     = 3
+	// deletePageSize is the number of objects listed at a time by DeleteDir
+	deletePageSize = 1000
+)
+
+// retryDelay is the delay before the first retry of a failed deletion, doubled on every retry
+var retryDelay = 100 * time.Millisecond
+
 type GCSBackend struct {
-	client     *storage.Client
-	bucketName string
-	context    context.Context
+	client            *storage.Client
+	bucketName        string
+	context           context.Context
+	deleteConcurrency int
+	deleteRetries     int
+	rootPrefix        string
+}
+
+type Option func(*GCSBackend)
+
+// WithDeleteConcurrency sets the number of objects DeleteDir deletes in parallel, defaults to 16
+func WithDeleteConcurrency(n int) Option {
+	return func(c *GCSBackend) {
+		c.deleteConcurrency = n
+	}
+}
+
+// WithDeleteRetries sets how many times DeleteDir retries deleting an object after a transient
+// error, defaults to 3
+func WithDeleteRetries(n int) Option {
+	return func(c *GCSBackend) {
+		c.deleteRetries = n
+	}
+}
+
+// WithRootPrefix stores every object under prefix in the bucket, so that several stores can share a
+// bucket. Paths are relative to prefix, and listings don't show anything outside of it.
+func WithRootPrefix(prefix string) Option {
+	return func(c *GCSBackend) {
+		c.rootPrefix = strings.Trim(prefix, "/")
+	}
+}
+
+func NewGCSBackend(client *storage.Client, bucketName string, ctx context.Context, opts ...Option) GCSBackend {
+	backend := GCSBackend{
+		client:            client,
+		bucketName:        bucketName,
+		context:           ctx,
+		deleteConcurrency: defaultDeleteConcurrency,
+		deleteRetries:     defaultDeleteRetries,
+	}
+	for _, opt := range opts {
+		opt(&backend)
+	}
+	if backend.deleteConcurrency < 1 {
+		backend.deleteConcurrency = 1
+	}
+	return backend
+}
+
+// DeleteDirError lists the objects DeleteDir or DeleteFiles failed to delete
+type DeleteDirError struct {
+	Failed map[string]error
+}
+
+func (e *DeleteDirError) Error() string {
+	return fmt.Sprintf("failed to delete %d object(s)", len(e.Failed))
+}
+
+// RootPrefix returns the prefix of the bucket the backend stores its objects under, if any
+func (c GCSBackend) RootPrefix() string {
+	return c.rootPrefix
 }
 
-func NewGCSBackend(client *storage.Client, bucketName string, ctx context.Context) GCSBackend {
-	return GCSBackend{
-		client:     client,
-		bucketName: bucketName,
-		context:    ctx,
+// objectName returns the name of the object of path in the bucket
+func (c GCSBackend) objectName(path string) string {
+	if c.rootPrefix == "" {
+		return path
 	}
+	return c.rootPrefix + "/" + strings.TrimLeft(path, "/")
+}
+
+func (c GCSBackend) object(path string) *storage.ObjectHandle {
+	return c.client.Bucket(c.bucketName).Object(c.objectName(path))
 }
 
 func (c GCSBackend) WriteFile(path string, value []byte) error {
-	bucket := c.client.Bucket(c.bucketName)
-	obj := bucket.Object(path)
-	w := obj.NewWriter(c.context)
+	w := c.object(path).NewWriter(c.context)
 	_, err := w.Write(value)
 	if err != nil {
 		return err
@@ -35,9 +117,7 @@ func (c GCSBackend) WriteFile(path string, value []byte) error {
 }
 
 func (c GCSBackend) ReadFile(path string) ([]byte, error) {
-	bucket := c.client.Bucket(c.bucketName)
-	obj := bucket.Object(path)
-	rc, err := obj.NewReader(c.context)
+	rc, err := c.object(path).NewReader(c.context)
 	if err != nil {
 		return nil, err
 	}
@@ -46,50 +126,174 @@ func (c GCSBackend) ReadFile(path string) ([]byte, error) {
 }
 
 func (c GCSBackend) DeleteFile(path string) error {
-	bucket := c.client.Bucket(c.bucketName)
-	return bucket.Object(path).Delete(c.context)
+	return c.object(path).Delete(c.context)
 }
 
+// DeleteDir lists the objects under path one page at a time, and deletes each page in parallel.
+// Objects that can't be deleted don't stop the deletion, they are reported with a *DeleteDirError.
 func (c GCSBackend) DeleteDir(path string) error {
+	path = c.objectName(path)
 	if !strings.HasSuffix(path, "/") {
 		path = path + "/"
 	}
 	it := c.client.Bucket(c.bucketName).Objects(c.context, &storage.Query{Prefix: path})
+	pager := iterator.NewPager(it, deletePageSize, "")
+	failed := make(map[string]error)
 	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
+		var page []*storage.ObjectAttrs
+		next, err := pager.NextPage(&page)
+		if err != nil {
+			return fmt.Errorf("failed to list objects under %s (%s)", path, err)
 		}
-		// Need to check further this is the best to way to skip the current "directory"
-		if attrs.Name != "" {
-			err = c.client.Bucket(c.bucketName).Object(attrs.Name).Delete(c.context)
-			if err != nil {
-				return err
+		var names []string
+		for _, attrs := range page {
+			// Need to check further this is the best to way to skip the current "directory"
+			if attrs.Name != "" {
+				names = append(names, attrs.Name)
 			}
 		}
+		for name, err := range c.deleteObjects(names) {
+			failed[name] = err
+		}
+		if next == "" {
+			break
+		}
+	}
+	if len(failed) > 0 {
+		return &DeleteDirError{Failed: failed}
 	}
 	return nil
 }
 
+// deleteObjects deletes names with c.deleteConcurrency workers, and returns the errors per object
+func (c GCSBackend) deleteObjects(names []string) map[string]error {
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < c.deleteConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				err := c.deleteObject(name)
+				if err != nil {
+					mu.Lock()
+					failed[name] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+// deleteObject deletes name, retrying transient errors with an exponential backoff
+func (c GCSBackend) deleteObject(name string) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.client.Bucket(c.bucketName).Object(name).Delete(c.context)
+		// Already deleted, possibly by a previous attempt whose response was lost
+		if err == nil || err == storage.ErrObjectNotExist {
+			return nil
+		}
+		if attempt >= c.deleteRetries || !IsTransient(err) {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-c.context.Done():
+			return c.context.Err()
+		}
+		delay *= 2
+	}
+}
+
+// IsTransient reports whether err is a GCS error worth retrying: rate limiting, server errors and
+// network failures. It can be used as the classifier of a retry.Policy.
+func IsTransient(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
 func (c GCSBackend) ListDir(path string) ([]string, error) {
-	it := c.client.Bucket(c.bucketName).Objects(c.context, &storage.Query{Prefix: path + "/", Delimiter: "/"})
+	prefix := listPrefix(c.objectName(path))
+	it := c.client.Bucket(c.bucketName).Objects(c.context, &storage.Query{Prefix: prefix, Delimiter: "/"})
 	var fileNames []string
 	for {
 		attrs, err := it.Next()
 		if err == iterator.Done {
 			break
 		}
-		if attrs.Name != "" {
-			fileNames = append(fileNames, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		if name := listName(attrs, prefix); name != "" {
+			fileNames = append(fileNames, name)
 		}
 	}
+	sort.Strings(fileNames)
 	return fileNames, nil
 }
 
+// ListDirPage uses the GCS page token as the cursor
+func (c GCSBackend) ListDirPage(path string, pageSize int, cursor string) ([]string, string, error) {
+	prefix := listPrefix(c.objectName(path))
+	it := c.client.Bucket(c.bucketName).Objects(c.context, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	var page []*storage.ObjectAttrs
+	next, err := iterator.NewPager(it, pageSize, cursor).NextPage(&page)
+	if err != nil {
+		return nil, "", err
+	}
+	var fileNames []string
+	for _, attrs := range page {
+		if name := listName(attrs, prefix); name != "" {
+			fileNames = append(fileNames, name)
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames, next, nil
+}
+
+func listPrefix(path string) string {
+	prefix := strings.Trim(path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// listName returns the name of a listed object relative to prefix. Sub-directories only show up as
+// prefixes, which keep their trailing "/". The placeholder object some tools create for the
+// directory itself has an empty name.
+func listName(attrs *storage.ObjectAttrs, prefix string) string {
+	if attrs.Prefix != "" {
+		return strings.TrimPrefix(attrs.Prefix, prefix)
+	}
+	return strings.TrimPrefix(attrs.Name, prefix)
+}
+
 func (c GCSBackend) Exist(path string) (bool, error) {
-	bucket := c.client.Bucket(c.bucketName)
-	obj := bucket.Object(path)
-	_, err := obj.Attrs(c.context)
+	_, err := c.object(path).Attrs(c.context)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
 			return false, nil
@@ -98,3 +302,79 @@ func (c GCSBackend) Exist(path string) (bool, error) {
 	}
 	return true, nil
 }
+
+// CopyFile copies src to dst server-side
+func (c GCSBackend) CopyFile(src string, dst string) error {
+	_, err := c.object(dst).CopierFrom(c.object(src)).Run(c.context)
+	return err
+}
+
+// MoveFile copies src to dst server-side and then deletes src, GCS has no native rename
+func (c GCSBackend) MoveFile(src string, dst string) error {
+	err := c.CopyFile(src, dst)
+	if err != nil {
+		return err
+	}
+	return c.DeleteFile(src)
+}
+
+func (c GCSBackend) OpenReader(path string) (io.ReadCloser, error) {
+	return c.object(path).NewReader(c.context)
+}
+
+func (c GCSBackend) OpenWriter(path string) (io.WriteCloser, error) {
+	return c.object(path).NewWriter(c.context), nil
+}
+
+// DeleteFiles deletes paths in parallel like DeleteDir, ignoring the ones that don't exist. Objects
+// that can't be deleted are reported with a *DeleteDirError.
+func (c GCSBackend) DeleteFiles(paths []string) error {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = c.objectName(path)
+	}
+	failed := c.deleteObjects(names)
+	if len(failed) > 0 {
+		return &DeleteDirError{Failed: failed}
+	}
+	return nil
+}
+
+// Version returns the generation of the object at path
+func (c GCSBackend) Version(path string) (string, error) {
+	attrs, err := c.object(path).Attrs(c.context)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return "", nil
+		}
+		return "", err
+	}
+	return strconv.FormatInt(attrs.Generation, 10), nil
+}
+
+// WriteFileIf relies on GCS generation preconditions, so it is safe across processes
+func (c GCSBackend) WriteFileIf(path string, value []byte, version string) error {
+	conds := storage.Conditions{DoesNotExist: true}
+	if version != "" {
+		generation, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return backends.ErrPreconditionFailed
+		}
+		conds = storage.Conditions{GenerationMatch: generation}
+	}
+	w := c.object(path).If(conds).NewWriter(c.context)
+	_, err := w.Write(value)
+	if err != nil {
+		w.Close()
+		return mapPreconditionError(err)
+	}
+	return mapPreconditionError(w.Close())
+}
+
+func mapPreconditionError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return backends.ErrPreconditionFailed
+	}
+	return err
+}
@@ -10,12 +10,23 @@ import (
 	"testing"
 
 	"cloud.google.com/go/storage"
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 const bucketName = "test"
 
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+		client := newClient(t)
+		t.Cleanup(func() { cleanupBucketPath(bucketName, "", client, t) })
+		return NewGCSBackend(client, bucketName, context.Background())
+	})
+}
+
 func TestWriteFile(t *testing.T) {
 	path := "svk-test-file"
 	testWriteFile(t, path)
@@ -86,6 +97,130 @@ func TestReadFile(t *testing.T) {
 
 }
 
+func TestCopyFile(t *testing.T) {
+	contents := []byte("test")
+	dir := "svk-test-dir"
+	path := fmt.Sprintf("%s/file", dir)
+	copyPath := fmt.Sprintf("%s/copy", dir)
+	client := newClient(t)
+	defer cleanupBucketPath(bucketName, dir, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx)
+	err := backend.WriteFile(path, contents)
+	if err != nil {
+		t.Errorf("CopyFile: failed to prepare (%s)", err)
+	}
+	err = backend.CopyFile(path, copyPath)
+	if err != nil {
+		t.Errorf("CopyFile: should not have failed (%s)", err)
+	}
+	fileBytes, err := backend.ReadFile(copyPath)
+	if err != nil {
+		t.Errorf("CopyFile: failed to read copy (%s)", err)
+	}
+	res := bytes.Compare(fileBytes, contents)
+	if res != 0 {
+		t.Errorf("CopyFile: copied value is different from original (expected '%s' got '%s')", contents, fileBytes)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	contents := []byte("test")
+	dir := "svk-test-dir"
+	path := fmt.Sprintf("%s/file", dir)
+	movePath := fmt.Sprintf("%s/moved", dir)
+	client := newClient(t)
+	defer cleanupBucketPath(bucketName, dir, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx)
+	err := backend.WriteFile(path, contents)
+	if err != nil {
+		t.Errorf("MoveFile: failed to prepare (%s)", err)
+	}
+	err = backend.MoveFile(path, movePath)
+	if err != nil {
+		t.Errorf("MoveFile: should not have failed (%s)", err)
+	}
+	found, err := backend.Exist(path)
+	if err != nil || found {
+		t.Errorf("MoveFile: source should have been removed")
+	}
+	fileBytes, err := backend.ReadFile(movePath)
+	if err != nil {
+		t.Errorf("MoveFile: failed to read destination (%s)", err)
+	}
+	res := bytes.Compare(fileBytes, contents)
+	if res != 0 {
+		t.Errorf("MoveFile: moved value is different from original (expected '%s' got '%s')", contents, fileBytes)
+	}
+}
+
+func TestOpenWriter(t *testing.T) {
+	contents := []byte("test")
+	path := "svk-test-file"
+	client := newClient(t)
+	defer cleanupBucketPath(bucketName, path, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx)
+	w, err := backend.OpenWriter(path)
+	if err != nil {
+		t.Fatalf("OpenWriter: should have succeeded (%s)", err)
+	}
+	_, err = w.Write(contents)
+	if err != nil {
+		t.Errorf("OpenWriter: write should have succeeded (%s)", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("OpenWriter: close should have succeeded (%s)", err)
+	}
+	rc, err := backend.OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader: should have succeeded (%s)", err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Errorf("OpenReader: failed to read file contents (%s)", err)
+	}
+	res := bytes.Compare(data, contents)
+	if res != 0 {
+		t.Errorf("OpenReader: stored value is different from original (expected '%s' got '%s')", contents, data)
+	}
+}
+
+func TestWriteFileIf(t *testing.T) {
+	path := "svk-test-file"
+	client := newClient(t)
+	defer cleanupBucketPath(bucketName, path, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx)
+	err := backend.WriteFileIf(path, []byte("v1"), "")
+	if err != nil {
+		t.Errorf("WriteFileIf: creating a new file should have succeeded (%s)", err)
+	}
+	err = backend.WriteFileIf(path, []byte("v1"), "")
+	if err != backends.ErrPreconditionFailed {
+		t.Errorf("WriteFileIf: expected ErrPreconditionFailed for an existing file, got %v", err)
+	}
+	version, err := backend.Version(path)
+	if err != nil || version == "" {
+		t.Fatalf("Version: should have returned a version (%v)", err)
+	}
+	err = backend.WriteFileIf(path, []byte("v2"), version)
+	if err != nil {
+		t.Errorf("WriteFileIf: writing with the current version should have succeeded (%s)", err)
+	}
+	err = backend.WriteFileIf(path, []byte("v3"), version)
+	if err != backends.ErrPreconditionFailed {
+		t.Errorf("WriteFileIf: expected ErrPreconditionFailed for a stale version, got %v", err)
+	}
+}
+
 func TestDeleteFile(t *testing.T) {
 	path := "svk-test-file"
 
@@ -201,6 +336,74 @@ func TestDeleteDir_WithSubDirs(t *testing.T) {
 	}
 }
 
+func TestDeleteDir_Parallel(t *testing.T) {
+	client := newClient(t)
+	dir := "svk-test-dir"
+	defer cleanupBucketPath(bucketName, dir, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx, WithDeleteConcurrency(4))
+	for i := 0; i < 25; i++ {
+		err := backend.WriteFile(fmt.Sprintf("%s/sub-%d/file", dir, i%3), []byte("test"))
+		if err != nil {
+			t.Errorf("DeleteDir: failed to prepare (%s)", err)
+		}
+	}
+	err := backend.DeleteDir(dir)
+	if err != nil {
+		t.Errorf("DeleteDir: should not have failed (%s)", err)
+	}
+	it := client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: dir})
+	_, err = it.Next()
+	if err != iterator.Done {
+		t.Errorf("DeleteDir: should have removed every object under %s", dir)
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	client := newClient(t)
+	dir := "svk-test-dir"
+	defer cleanupBucketPath(bucketName, dir, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx, WithDeleteConcurrency(4))
+	var paths []string
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("%s/file-%d", dir, i)
+		err := backend.WriteFile(path, []byte("test"))
+		if err != nil {
+			t.Errorf("DeleteFiles: failed to prepare (%s)", err)
+		}
+		paths = append(paths, path)
+	}
+	err := backend.DeleteFiles(append(paths[1:], dir+"/missing"))
+	if err != nil {
+		t.Errorf("DeleteFiles: should not have failed (%s)", err)
+	}
+	files, err := backend.ListDir(dir)
+	if err != nil || len(files) != 1 || files[0] != "file-0" {
+		t.Errorf("DeleteFiles: expected only file-0 left, got %v (%v)", files, err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusBadGateway}), true},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{storage.ErrObjectNotExist, false},
+	}
+	for _, test := range tests {
+		if IsTransient(test.err) != test.transient {
+			t.Errorf("IsTransient: expected %t for %v", test.transient, test.err)
+		}
+	}
+}
+
 func TestList_Empty(t *testing.T) {
 	client := newClient(t)
 	dir := "svk-test-dir"
@@ -286,6 +489,35 @@ func TestList_Multiple(t *testing.T) {
 	}
 }
 
+func TestListDirPage(t *testing.T) {
+	dir := "svk-test-dir"
+	client := newClient(t)
+	defer cleanupBucketPath(bucketName, dir, client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx)
+	for _, name := range []string{"a", "b", "c"} {
+		err := backend.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte("test"))
+		if err != nil {
+			t.Errorf("ListDirPage: failed to prepare (%s)", err)
+		}
+	}
+	files, cursor, err := backend.ListDirPage(dir, 2, "")
+	if err != nil {
+		t.Errorf("ListDirPage: should not have failed (%s)", err)
+	}
+	if len(files) != 2 || cursor == "" {
+		t.Errorf("ListDirPage: expected a first page of 2 files and a cursor, got %v '%s'", files, cursor)
+	}
+	files, cursor, err = backend.ListDirPage(dir, 2, cursor)
+	if err != nil {
+		t.Errorf("ListDirPage: should not have failed (%s)", err)
+	}
+	if len(files) != 1 || cursor != "" {
+		t.Errorf("ListDirPage: expected a last page of 1 file, got %v '%s'", files, cursor)
+	}
+}
+
 func cleanupBucketPath(bucketName string, path string, client *storage.Client, t *testing.T) {
 	ctx := context.Background()
 	it := client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: path})
@@ -323,3 +555,37 @@ func newClient(t *testing.T) *storage.Client {
 	}
 	return client
 }
+
+func TestRootPrefix(t *testing.T) {
+	client := newClient(t)
+	root := "svk-test-root"
+	defer cleanupBucketPath(bucketName, root, client, t)
+	defer cleanupBucketPath(bucketName, "svk-test-outside", client, t)
+
+	ctx := context.Background()
+	backend := NewGCSBackend(client, bucketName, ctx, WithRootPrefix("/"+root+"/"))
+	err := backend.WriteFile("dir/file", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestRootPrefix: WriteFile should have succeeded (%s)", err)
+	}
+	err = NewGCSBackend(client, bucketName, ctx).WriteFile("svk-test-outside", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestRootPrefix: WriteFile should have succeeded (%s)", err)
+	}
+	_, err = client.Bucket(bucketName).Object(root + "/dir/file").Attrs(ctx)
+	if err != nil {
+		t.Errorf("TestRootPrefix: the object should be stored under the root prefix (%s)", err)
+	}
+	files, err := backend.ListDir("")
+	if err != nil || len(files) != 1 || files[0] != "dir/" {
+		t.Errorf("TestRootPrefix: expected to only list dir/, got %v (%v)", files, err)
+	}
+	err = backend.DeleteDir("dir")
+	if err != nil {
+		t.Errorf("TestRootPrefix: DeleteDir should have succeeded (%s)", err)
+	}
+	found, err := backend.Exist("dir/file")
+	if err != nil || found {
+		t.Errorf("TestRootPrefix: the object should have been deleted (%v)", err)
+	}
+}
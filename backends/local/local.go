@@ -1,15 +1,35 @@
 package local
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/marcelocarlos/multikv/backends"
 	"golang.org/x/sys/unix"
 )
 
+// tmpPrefix is used for files being written, which are never listed
+const tmpPrefix = ".multikv-tmp-"
+
+// ErrOutsideBasePath is returned for paths that would resolve outside of BasePath, e.g. "../x"
+var ErrOutsideBasePath = errors.New("path is outside of the base path")
+
+// conditionalWriteMu makes WriteFileIf atomic, within this process only
+var conditionalWriteMu sync.Mutex
+
 type LocalBackend struct {
 	BasePath string
+	// WatchInterval is how often Watch scans for changes, defaults to 1s
+	WatchInterval time.Duration
 }
 
 func NewLocalBackend(basePath string) (LocalBackend, error) {
@@ -23,55 +43,97 @@ func NewLocalBackend(basePath string) (LocalBackend, error) {
 	return backend, nil
 }
 
+// fullPath returns where path is stored. Paths use forward slashes on every OS, like in object stores.
+func (c LocalBackend) fullPath(op string, path string) (string, error) {
+	fullPath := filepath.Join(c.BasePath, filepath.FromSlash(path))
+	rel, err := filepath.Rel(filepath.Clean(c.BasePath), fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: op, Path: path, Err: ErrOutsideBasePath}
+	}
+	return fullPath, nil
+}
+
 func (c LocalBackend) WriteFile(path string, value []byte) error {
-	keyPath := filepath.Join(c.BasePath, path)
-	_, err := os.Stat(filepath.Dir(keyPath))
-	if os.IsNotExist(err) {
-		err := os.MkdirAll(filepath.Dir(keyPath), 0750)
-		if err != nil {
-			return err
-		}
+	w, err := c.OpenWriter(path)
+	if err != nil {
+		return err
 	}
-	file, err := os.OpenFile(keyPath, os.O_RDWR|os.O_CREATE, 0640) // O_RDONLY mode
+	_, err = w.Write(value)
 	if err != nil {
+		w.Close()
 		return err
 	}
-	defer file.Close()
-	_, err = file.Write(value)
-	return err
+	return w.Close()
 }
 
 func (c LocalBackend) ReadFile(path string) ([]byte, error) {
-	keyPath := filepath.Join(c.BasePath, path)
+	keyPath, err := c.fullPath("open", path)
+	if err != nil {
+		return nil, err
+	}
 	return ioutil.ReadFile(keyPath)
 }
 
 func (c LocalBackend) DeleteFile(path string) error {
-	keyPath := filepath.Join(c.BasePath, path)
+	keyPath, err := c.fullPath("remove", path)
+	if err != nil {
+		return err
+	}
 	return os.Remove(keyPath)
 }
 
 func (c LocalBackend) DeleteDir(path string) error {
-	keyPath := filepath.Join(c.BasePath, path)
+	keyPath, err := c.fullPath("remove", path)
+	if err != nil {
+		return err
+	}
 	return os.RemoveAll(keyPath)
 }
 
 func (c LocalBackend) ListDir(path string) ([]string, error) {
-	keyPath := filepath.Join(c.BasePath, path)
+	keyPath, err := c.fullPath("open", path)
+	if err != nil {
+		return nil, err
+	}
 	fi, err := ioutil.ReadDir(keyPath)
 	if err != nil {
+		// Like object stores, a directory that doesn't exist is just empty
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
 		return nil, err
 	}
 	var files []string
 	for _, f := range fi {
+		if strings.HasPrefix(f.Name(), tmpPrefix) {
+			continue
+		}
+		if f.IsDir() {
+			files = append(files, f.Name()+"/")
+			continue
+		}
 		files = append(files, f.Name())
 	}
+	// The trailing "/" of directories can change the order ReadDir returned them in
+	sort.Strings(files)
 	return files, nil
 }
 
+func (c LocalBackend) ListDirPage(path string, pageSize int, cursor string) ([]string, string, error) {
+	files, err := c.ListDir(path)
+	if err != nil {
+		return nil, "", err
+	}
+	page, next := backends.PageNames(files, pageSize, cursor)
+	return page, next, nil
+}
+
 func (c LocalBackend) Exist(path string) (bool, error) {
-	keyPath := filepath.Join(c.BasePath, path)
-	_, err := os.Stat(keyPath)
+	keyPath, err := c.fullPath("stat", path)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(keyPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -80,3 +142,128 @@ func (c LocalBackend) Exist(path string) (bool, error) {
 	}
 	return true, nil
 }
+
+// CopyFile hardlinks dst to src, which is safe because WriteFile never modifies files in place
+func (c LocalBackend) CopyFile(src string, dst string) error {
+	srcPath, err := c.fullPath("link", src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := c.fullPath("link", dst)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dstPath), 0750)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(dstPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(srcPath, dstPath)
+}
+
+func (c LocalBackend) MoveFile(src string, dst string) error {
+	srcPath, err := c.fullPath("rename", src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := c.fullPath("rename", dst)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dstPath), 0750)
+	if err != nil {
+		return err
+	}
+	return os.Rename(srcPath, dstPath)
+}
+
+func (c LocalBackend) OpenReader(path string) (io.ReadCloser, error) {
+	keyPath, err := c.fullPath("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(keyPath)
+}
+
+// OpenWriter writes to a temporary file which replaces path on Close. Files are never modified in
+// place, so readers don't see partial writes and hardlinked copies (see CopyFile) keep their contents.
+func (c LocalBackend) OpenWriter(path string) (io.WriteCloser, error) {
+	keyPath, err := c.fullPath("open", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Dir(keyPath))
+	if os.IsNotExist(err) {
+		err := os.MkdirAll(filepath.Dir(keyPath), 0750)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file, err := ioutil.TempFile(filepath.Dir(keyPath), tmpPrefix+filepath.Base(keyPath))
+	if err != nil {
+		return nil, err
+	}
+	return &atomicWriter{file: file, path: keyPath}, nil
+}
+
+type atomicWriter struct {
+	file *os.File
+	path string
+	err  error
+}
+
+func (w *atomicWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *atomicWriter) Close() error {
+	defer os.Remove(w.file.Name())
+	if w.err != nil {
+		w.file.Close()
+		return w.err
+	}
+	err := w.file.Chmod(0640)
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	err = w.file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
+
+// Version returns a hash of the contents of path
+func (c LocalBackend) Version(path string) (string, error) {
+	data, err := c.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// WriteFileIf only protects against concurrent writers within the same process
+func (c LocalBackend) WriteFileIf(path string, data []byte, version string) error {
+	conditionalWriteMu.Lock()
+	defer conditionalWriteMu.Unlock()
+	current, err := c.Version(path)
+	if err != nil {
+		return err
+	}
+	if current != version {
+		return backends.ErrPreconditionFailed
+	}
+	return c.WriteFile(path, data)
+}
@@ -2,12 +2,33 @@ package local
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
 )
 
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+		basePath, err := ioutil.TempDir("", "multikv-test-dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(basePath) })
+		backend, err := NewLocalBackend(basePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	})
+}
+
 func TestNewBackend(t *testing.T) {
 	baseDir, err := ioutil.TempDir("", "multikv-test-dir")
 	if err != nil {
@@ -227,3 +248,237 @@ func TestList(t *testing.T) {
 		t.Errorf("ListDir: should have return a list with one element")
 	}
 }
+
+func TestCopyFile(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+	backend, err := NewLocalBackend(basePath)
+	if err != nil {
+		t.Errorf("CopyFile: should have succeeded (%s)", err)
+	}
+	contents := []byte("test")
+	err = backend.WriteFile("src", contents)
+	if err != nil {
+		t.Errorf("CopyFile: failed to prepare (%s)", err)
+	}
+	err = backend.CopyFile("src", "sub/dst")
+	if err != nil {
+		t.Errorf("CopyFile: should not have failed (%s)", err)
+	}
+	// Overwriting the source must not change the copy
+	err = backend.WriteFile("src", []byte("updated"))
+	if err != nil {
+		t.Errorf("CopyFile: failed to overwrite source (%s)", err)
+	}
+	fileBytes, err := backend.ReadFile("sub/dst")
+	if err != nil {
+		t.Errorf("CopyFile: failed to read copy (%s)", err)
+	}
+	res := bytes.Compare(fileBytes, contents)
+	if res != 0 {
+		t.Errorf("CopyFile: copied value is different from original (expected '%s' got '%s')", contents, fileBytes)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+	backend, err := NewLocalBackend(basePath)
+	if err != nil {
+		t.Errorf("MoveFile: should have succeeded (%s)", err)
+	}
+	contents := []byte("test")
+	err = backend.WriteFile("src", contents)
+	if err != nil {
+		t.Errorf("MoveFile: failed to prepare (%s)", err)
+	}
+	err = backend.MoveFile("src", "sub/dst")
+	if err != nil {
+		t.Errorf("MoveFile: should not have failed (%s)", err)
+	}
+	found, err := backend.Exist("src")
+	if err != nil || found {
+		t.Errorf("MoveFile: source should have been removed")
+	}
+	fileBytes, err := backend.ReadFile("sub/dst")
+	if err != nil {
+		t.Errorf("MoveFile: failed to read destination (%s)", err)
+	}
+	res := bytes.Compare(fileBytes, contents)
+	if res != 0 {
+		t.Errorf("MoveFile: moved value is different from original (expected '%s' got '%s')", contents, fileBytes)
+	}
+}
+
+func TestOpenWriter(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+	backend, err := NewLocalBackend(basePath)
+	if err != nil {
+		t.Errorf("OpenWriter: should have succeeded (%s)", err)
+	}
+	w, err := backend.OpenWriter("sub/file")
+	if err != nil {
+		t.Fatalf("OpenWriter: should have succeeded (%s)", err)
+	}
+	contents := []byte("test")
+	_, err = w.Write(contents)
+	if err != nil {
+		t.Errorf("OpenWriter: write should have succeeded (%s)", err)
+	}
+	// Nothing is visible until the writer is closed
+	found, _ := backend.Exist("sub/file")
+	if found {
+		t.Errorf("OpenWriter: file should not exist before Close")
+	}
+	fileNames, _ := backend.ListDir("sub")
+	if len(fileNames) != 0 {
+		t.Errorf("OpenWriter: temporary files should not be listed, got %v", fileNames)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("OpenWriter: close should have succeeded (%s)", err)
+	}
+
+	rc, err := backend.OpenReader("sub/file")
+	if err != nil {
+		t.Fatalf("OpenReader: should have succeeded (%s)", err)
+	}
+	defer rc.Close()
+	fileBytes, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Errorf("OpenReader: failed to read file contents (%s)", err)
+	}
+	res := bytes.Compare(fileBytes, contents)
+	if res != 0 {
+		t.Errorf("OpenReader: stored value is different from original (expected '%s' got '%s')", contents, fileBytes)
+	}
+}
+
+func TestWriteFileIf(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+	backend, err := NewLocalBackend(basePath)
+	if err != nil {
+		t.Errorf("WriteFileIf: should have succeeded (%s)", err)
+	}
+	err = backend.WriteFileIf("file", []byte("v1"), "")
+	if err != nil {
+		t.Errorf("WriteFileIf: creating a new file should have succeeded (%s)", err)
+	}
+	err = backend.WriteFileIf("file", []byte("v1"), "")
+	if err != backends.ErrPreconditionFailed {
+		t.Errorf("WriteFileIf: expected ErrPreconditionFailed for an existing file, got %v", err)
+	}
+	version, err := backend.Version("file")
+	if err != nil || version == "" {
+		t.Fatalf("Version: should have returned a version (%v)", err)
+	}
+	err = backend.WriteFileIf("file", []byte("v2"), version)
+	if err != nil {
+		t.Errorf("WriteFileIf: writing with the current version should have succeeded (%s)", err)
+	}
+	err = backend.WriteFileIf("file", []byte("v3"), version)
+	if err != backends.ErrPreconditionFailed {
+		t.Errorf("WriteFileIf: expected ErrPreconditionFailed for a stale version, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+	backend, err := NewLocalBackend(basePath)
+	if err != nil {
+		t.Errorf("Watch: should have succeeded (%s)", err)
+	}
+	backend.WatchInterval = 10 * time.Millisecond
+	err = backend.WriteFile("dir/existing", []byte("test"))
+	if err != nil {
+		t.Errorf("Watch: failed to prepare (%s)", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := backend.Watch(ctx, "dir")
+	if err != nil {
+		t.Fatalf("Watch: should have succeeded (%s)", err)
+	}
+	err = backend.WriteFile("dir/new", []byte("test"))
+	if err != nil {
+		t.Errorf("Watch: failed to write file (%s)", err)
+	}
+	expectEvent(t, events, backends.Event{Type: backends.EventWrite, Path: "dir/new"})
+	err = backend.DeleteFile("dir/existing")
+	if err != nil {
+		t.Errorf("Watch: failed to delete file (%s)", err)
+	}
+	expectEvent(t, events, backends.Event{Type: backends.EventDelete, Path: "dir/existing"})
+
+	cancel()
+	for range events {
+	}
+}
+
+func expectEvent(t *testing.T, events <-chan backends.Event, expected backends.Event) {
+	select {
+	case event := <-events:
+		if event != expected {
+			t.Errorf("Watch: expected %+v, got %+v", expected, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Watch: expected %+v, got nothing", expected)
+	}
+}
+
+func TestOutsideBasePath(t *testing.T) {
+	parent, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	baseDir := filepath.Join(parent, "store")
+	err = os.Mkdir(baseDir, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewLocalBackend(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"../escaped", "a/../../escaped", "/../escaped"} {
+		err = backend.WriteFile(path, []byte("test"))
+		if !errors.Is(err, ErrOutsideBasePath) {
+			t.Errorf("WriteFile: %s should have been rejected, got %v", path, err)
+		}
+		_, err = backend.ReadFile(path)
+		if !errors.Is(err, ErrOutsideBasePath) {
+			t.Errorf("ReadFile: %s should have been rejected, got %v", path, err)
+		}
+	}
+	err = backend.DeleteDir("..")
+	if !errors.Is(err, ErrOutsideBasePath) {
+		t.Errorf("DeleteDir: the parent of the base path should have been rejected, got %v", err)
+	}
+	_, err = os.Stat(filepath.Join(parent, "escaped"))
+	if !os.IsNotExist(err) {
+		t.Errorf("TestOutsideBasePath: a file was written outside of the base path")
+	}
+	// Still inside the base path
+	err = backend.WriteFile("a/../b", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: a/../b should have been written (%s)", err)
+	}
+}
@@ -0,0 +1,98 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// Watch polls the files under path every WatchInterval and reports the ones that were written or
+// deleted since the previous scan
+func (c LocalBackend) Watch(ctx context.Context, path string) (<-chan backends.Event, error) {
+	interval := c.WatchInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	root, err := c.fullPath("watch", path)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := scanFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan backends.Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := scanFiles(root)
+			if err != nil {
+				// Transient errors (e.g. files removed while scanning) are picked up by the next scan
+				continue
+			}
+			for rel, fi := range current {
+				if old, ok := previous[rel]; !ok || !sameFile(old, fi) {
+					if !sendEvent(ctx, events, backends.Event{Type: backends.EventWrite, Path: filepath.ToSlash(filepath.Join(path, rel))}) {
+						return
+					}
+				}
+			}
+			for rel := range previous {
+				if _, ok := current[rel]; !ok {
+					if !sendEvent(ctx, events, backends.Event{Type: backends.EventDelete, Path: filepath.ToSlash(filepath.Join(path, rel))}) {
+						return
+					}
+				}
+			}
+			previous = current
+		}
+	}()
+	return events, nil
+}
+
+func sendEvent(ctx context.Context, events chan<- backends.Event, event backends.Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sameFile tells whether a file was left untouched. WriteFile replaces files, so the inode changes.
+func sameFile(a os.FileInfo, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+func scanFiles(root string) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), tmpPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = fi
+		return nil
+	})
+	return files, err
+}
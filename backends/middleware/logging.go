@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/internal/logfmt"
+)
+
+// Logging logs every operation to logger as a line of key=value pairs, e.g.:
+//
+//	op=ReadFile path=users/alice/data duration=1.52ms bytes=128
+//	op=ReadFile path=users/bob/data duration=0.98ms bytes=0 err="open users/bob/data: no such file or directory"
+func Logging(logger *log.Logger) backends.Middleware {
+	return func(next backends.KvBackend) backends.KvBackend {
+		return &observedBackend{
+			next: next,
+			observe: func(op string, path string, call func() (int, error)) error {
+				start := time.Now()
+				n, err := call()
+				line := "op=" + op + " path=" + logfmt.Value(path) + " duration=" + formatDuration(time.Since(start)) +
+					" bytes=" + strconv.Itoa(n)
+				if err != nil {
+					line += " err=" + logfmt.Value(err.Error())
+				}
+				logger.Print(line)
+				return err
+			},
+		}
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + "ms"
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	backend := backends.Chain(newTestBackend(t), Logging(log.New(&buf, "", 0)))
+	err := backend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("Logging: WriteFile should have succeeded (%s)", err)
+	}
+	_, err = backend.ReadFile("test/missing key")
+	if err == nil {
+		t.Fatalf("Logging: ReadFile should have failed")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Logging: expected 2 lines, got %q", lines)
+	}
+	expected := []*regexp.Regexp{
+		regexp.MustCompile(`^op=WriteFile path=test/key duration=[0-9.]+ms bytes=4$`),
+		regexp.MustCompile(`^op=ReadFile path="test/missing key" duration=[0-9.]+ms bytes=0 err=".*no such file or directory"$`),
+	}
+	for i, re := range expected {
+		if !re.MatchString(lines[i]) {
+			t.Errorf("Logging: line %q does not match %s", lines[i], re)
+		}
+	}
+}
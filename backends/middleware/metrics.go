@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+type MetricsOptions struct {
+	// PrefixDepth is the number of path segments operations are grouped by. Defaults to 1.
+	PrefixDepth int
+	// Buckets of the latency histogram, in seconds. Defaults to DefaultBuckets.
+	Buckets []float64
+}
+
+// Metrics counts the operations, errors and bytes of the backends it wraps, and measures their
+// latency, per operation and path prefix. They are exposed in the Prometheus text format by
+// WritePrometheus, or by serving Metrics over HTTP.
+type Metrics struct {
+	opts   MetricsOptions
+	mu     sync.Mutex
+	series map[seriesKey]*series
+}
+
+type seriesKey struct {
+	op     string
+	prefix string
+}
+
+type series struct {
+	count   uint64
+	errors  uint64
+	bytes   uint64
+	latency Histogram
+}
+
+func NewMetrics(opts MetricsOptions) *Metrics {
+	if opts.PrefixDepth < 1 {
+		opts.PrefixDepth = 1
+	}
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = DefaultBuckets
+	}
+	return &Metrics{
+		opts:   opts,
+		series: make(map[seriesKey]*series),
+	}
+}
+
+func (m *Metrics) Middleware() backends.Middleware {
+	return func(next backends.KvBackend) backends.KvBackend {
+		return &observedBackend{next: next, observe: m.observe}
+	}
+}
+
+func (m *Metrics) observe(op string, path string, call func() (int, error)) error {
+	start := time.Now()
+	n, err := call()
+	elapsed := time.Since(start).Seconds()
+
+	key := seriesKey{op: op, prefix: Prefix(path, m.opts.PrefixDepth)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{latency: NewHistogram(m.opts.Buckets)}
+		m.series[key] = s
+	}
+	s.count++
+	if err != nil {
+		s.errors++
+	}
+	s.bytes += uint64(n)
+	s.latency.Observe(elapsed)
+	return err
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	keys := make([]seriesKey, 0, len(m.series))
+	snapshot := make(map[seriesKey]series, len(m.series))
+	for key, s := range m.series {
+		keys = append(keys, key)
+		copied := *s
+		copied.latency = s.latency.Copy()
+		snapshot[key] = copied
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].prefix < keys[j].prefix
+	})
+
+	pw := NewPrometheusWriter(w)
+	counters := []struct {
+		name  string
+		help  string
+		value func(s series) uint64
+	}{
+		{"multikv_backend_operations_total", "Number of backend operations.", func(s series) uint64 { return s.count }},
+		{"multikv_backend_errors_total", "Number of backend operations that failed.", func(s series) uint64 { return s.errors }},
+		{"multikv_backend_bytes_total", "Number of bytes read or written by backend operations.", func(s series) uint64 { return s.bytes }},
+	}
+	for _, counter := range counters {
+		pw.Header(counter.name, "counter", counter.help)
+		for _, key := range keys {
+			pw.Sample(counter.name, labels(key), counter.value(snapshot[key]))
+		}
+	}
+	name := "multikv_backend_operation_duration_seconds"
+	pw.Header(name, "histogram", "Latency of backend operations.")
+	for _, key := range keys {
+		s := snapshot[key]
+		pw.Histogram(name, labels(key), s.latency, s.count)
+	}
+	return pw.Err()
+}
+
+// ServeHTTP serves the metrics, to be scraped by Prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.WritePrometheus(w)
+}
+
+func labels(key seriesKey) string {
+	return Labels("op", key.op, "prefix", key.prefix)
+}
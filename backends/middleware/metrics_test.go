@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(MetricsOptions{Buckets: []float64{60}})
+	backend := backends.Chain(newTestBackend(t), metrics.Middleware())
+	for _, path := range []string{"users/alice", "users/bob"} {
+		err := backend.WriteFile(path, []byte("test"))
+		if err != nil {
+			t.Fatalf("Metrics: WriteFile should have succeeded (%s)", err)
+		}
+	}
+	_, err := backend.ReadFile("users/alice")
+	if err != nil {
+		t.Fatalf("Metrics: ReadFile should have succeeded (%s)", err)
+	}
+	_, err = backend.ReadFile("config/missing")
+	if err == nil {
+		t.Fatalf("Metrics: ReadFile should have failed")
+	}
+
+	var buf bytes.Buffer
+	err = metrics.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatalf("Metrics: WritePrometheus should not have failed (%s)", err)
+	}
+	expected := []string{
+		"# TYPE multikv_backend_operations_total counter",
+		`multikv_backend_operations_total{op="WriteFile",prefix="users"} 2`,
+		`multikv_backend_operations_total{op="ReadFile",prefix="config"} 1`,
+		`multikv_backend_errors_total{op="ReadFile",prefix="config"} 1`,
+		`multikv_backend_errors_total{op="ReadFile",prefix="users"} 0`,
+		`multikv_backend_bytes_total{op="WriteFile",prefix="users"} 8`,
+		"# TYPE multikv_backend_operation_duration_seconds histogram",
+		`multikv_backend_operation_duration_seconds_bucket{op="ReadFile",prefix="users",le="60"} 1`,
+		`multikv_backend_operation_duration_seconds_bucket{op="ReadFile",prefix="users",le="+Inf"} 1`,
+		`multikv_backend_operation_duration_seconds_count{op="WriteFile",prefix="users"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Metrics: expected line %q in:\n%s", line, buf.String())
+		}
+	}
+}
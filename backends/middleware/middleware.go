@@ -0,0 +1,226 @@
+// Package middleware provides backends.Middleware implementations to observe what a backend does:
+// Logging, Metrics and Tracing.
+package middleware
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+const (
+	OpExist      = "Exist"
+	OpListDir    = "ListDir"
+	OpDeleteDir  = "DeleteDir"
+	OpDeleteFile = "DeleteFile"
+	OpReadFile   = "ReadFile"
+	OpWriteFile  = "WriteFile"
+
+	OpListDirPage = "ListDirPage"
+	OpCopyFile    = "CopyFile"
+	OpMoveFile    = "MoveFile"
+	OpVersion     = "Version"
+	OpWriteFileIf = "WriteFileIf"
+	OpOpenReader  = "OpenReader"
+	OpOpenWriter  = "OpenWriter"
+	OpDeleteFiles = "DeleteFiles"
+	OpWatch       = "Watch"
+)
+
+// observer is called around every operation. call returns the number of bytes read or written.
+type observer func(op string, path string, call func() (int, error)) error
+
+// observedBackend calls observe around every operation of the backend it wraps, including the
+// optional ones, which fail with backends.ErrNotSupported if the wrapped backend lacks them. Copies
+// and moves are observed on their source, batch deletes on their first path, and streams and watches
+// only while they are opened.
+type observedBackend struct {
+	next    backends.KvBackend
+	observe observer
+}
+
+func (c *observedBackend) Exist(path string) (bool, error) {
+	var found bool
+	err := c.observe(OpExist, path, func() (int, error) {
+		var err error
+		found, err = c.next.Exist(path)
+		return 0, err
+	})
+	return found, err
+}
+
+func (c *observedBackend) ListDir(path string) ([]string, error) {
+	var files []string
+	err := c.observe(OpListDir, path, func() (int, error) {
+		var err error
+		files, err = c.next.ListDir(path)
+		return 0, err
+	})
+	return files, err
+}
+
+func (c *observedBackend) DeleteDir(path string) error {
+	return c.observe(OpDeleteDir, path, func() (int, error) {
+		return 0, c.next.DeleteDir(path)
+	})
+}
+
+func (c *observedBackend) DeleteFile(path string) error {
+	return c.observe(OpDeleteFile, path, func() (int, error) {
+		return 0, c.next.DeleteFile(path)
+	})
+}
+
+func (c *observedBackend) ReadFile(path string) ([]byte, error) {
+	var data []byte
+	err := c.observe(OpReadFile, path, func() (int, error) {
+		var err error
+		data, err = c.next.ReadFile(path)
+		return len(data), err
+	})
+	return data, err
+}
+
+func (c *observedBackend) WriteFile(path string, data []byte) error {
+	return c.observe(OpWriteFile, path, func() (int, error) {
+		err := c.next.WriteFile(path, data)
+		if err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	})
+}
+
+func (c *observedBackend) Unwrap() backends.KvBackend {
+	return c.next
+}
+
+func (c *observedBackend) ListDirPage(path string, pageSize int, cursor string) ([]string, string, error) {
+	pager, ok := c.next.(backends.Pager)
+	if !ok {
+		return nil, "", backends.ErrNotSupported
+	}
+	var files []string
+	var next string
+	err := c.observe(OpListDirPage, path, func() (int, error) {
+		var err error
+		files, next, err = pager.ListDirPage(path, pageSize, cursor)
+		return 0, err
+	})
+	return files, next, err
+}
+
+func (c *observedBackend) CopyFile(src string, dst string) error {
+	copier, ok := c.next.(backends.Copier)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	return c.observe(OpCopyFile, src, func() (int, error) {
+		return 0, copier.CopyFile(src, dst)
+	})
+}
+
+func (c *observedBackend) MoveFile(src string, dst string) error {
+	mover, ok := c.next.(backends.Mover)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	return c.observe(OpMoveFile, src, func() (int, error) {
+		return 0, mover.MoveFile(src, dst)
+	})
+}
+
+func (c *observedBackend) Version(path string) (string, error) {
+	writer, ok := c.next.(backends.ConditionalWriter)
+	if !ok {
+		return "", backends.ErrNotSupported
+	}
+	var version string
+	err := c.observe(OpVersion, path, func() (int, error) {
+		var err error
+		version, err = writer.Version(path)
+		return 0, err
+	})
+	return version, err
+}
+
+func (c *observedBackend) WriteFileIf(path string, data []byte, version string) error {
+	writer, ok := c.next.(backends.ConditionalWriter)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	return c.observe(OpWriteFileIf, path, func() (int, error) {
+		err := writer.WriteFileIf(path, data, version)
+		if err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	})
+}
+
+func (c *observedBackend) OpenReader(path string) (io.ReadCloser, error) {
+	streamer, ok := c.next.(backends.Streamer)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	var rc io.ReadCloser
+	err := c.observe(OpOpenReader, path, func() (int, error) {
+		var err error
+		rc, err = streamer.OpenReader(path)
+		return 0, err
+	})
+	return rc, err
+}
+
+func (c *observedBackend) OpenWriter(path string) (io.WriteCloser, error) {
+	streamer, ok := c.next.(backends.Streamer)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	var w io.WriteCloser
+	err := c.observe(OpOpenWriter, path, func() (int, error) {
+		var err error
+		w, err = streamer.OpenWriter(path)
+		return 0, err
+	})
+	return w, err
+}
+
+func (c *observedBackend) DeleteFiles(paths []string) error {
+	deleter, ok := c.next.(backends.BatchDeleter)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	path := ""
+	if len(paths) > 0 {
+		path = paths[0]
+	}
+	return c.observe(OpDeleteFiles, path, func() (int, error) {
+		return 0, deleter.DeleteFiles(paths)
+	})
+}
+
+func (c *observedBackend) Watch(ctx context.Context, path string) (<-chan backends.Event, error) {
+	watcher, ok := c.next.(backends.Watcher)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	var events <-chan backends.Event
+	err := c.observe(OpWatch, path, func() (int, error) {
+		var err error
+		events, err = watcher.Watch(ctx, path)
+		return 0, err
+	})
+	return events, err
+}
+
+// Prefix returns the first depth segments of path, which is how Metrics and Tracing group operations
+func Prefix(path string, depth int) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if depth < len(segments) {
+		segments = segments[:depth]
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
+	"github.com/marcelocarlos/multikv/backends/local"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+func newTestBackend(t *testing.T) backends.KvBackend {
+	dir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	backend, err := local.NewLocalBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+		return backends.Chain(newTestBackend(t),
+			Logging(log.New(ioutil.Discard, "", 0)),
+			NewMetrics(MetricsOptions{}).Middleware(),
+			Tracing(trace.NewTracerProvider().Tracer("test"), 1),
+		)
+	})
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) backends.Middleware {
+		return func(next backends.KvBackend) backends.KvBackend {
+			return &observedBackend{
+				next: next,
+				observe: func(op string, path string, call func() (int, error)) error {
+					calls = append(calls, name)
+					_, err := call()
+					return err
+				},
+			}
+		}
+	}
+	backend := backends.Chain(newTestBackend(t), record("outer"), record("inner"))
+	_, err := backend.Exist("test")
+	if err != nil {
+		t.Errorf("Chain: should not have failed (%s)", err)
+	}
+	if len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+		t.Errorf("Chain: expected middlewares to be called outer first, got %v", calls)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		path     string
+		depth    int
+		expected string
+	}{
+		{"users/alice/data", 1, "users"},
+		{"users/alice/data", 2, "users/alice"},
+		{"/users/alice/data", 5, "users/alice/data"},
+		{"", 1, ""},
+	}
+	for _, test := range tests {
+		if prefix := Prefix(test.path, test.depth); prefix != test.expected {
+			t.Errorf("Prefix: expected '%s' for %s at depth %d, got '%s'", test.expected, test.path, test.depth, prefix)
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	backend := newTestBackend(t)
+	var ops []string
+	observed := &observedBackend{
+		next: backend,
+		observe: func(op string, path string, call func() (int, error)) error {
+			ops = append(ops, op+" "+path)
+			_, err := call()
+			return err
+		},
+	}
+	if c := backends.CapabilitiesOf(observed); c != backends.CapabilitiesOf(backend) {
+		t.Errorf("Capabilities: expected the capabilities of the wrapped backend, got %+v", c)
+	}
+	err := observed.WriteFile("a", []byte("test"))
+	if err != nil {
+		t.Fatalf("Capabilities: failed to prepare (%s)", err)
+	}
+	err = observed.CopyFile("a", "b")
+	if err != nil {
+		t.Errorf("Capabilities: CopyFile should have succeeded (%s)", err)
+	}
+	_, _, err = observed.ListDirPage("", 1, "")
+	if err != nil {
+		t.Errorf("Capabilities: ListDirPage should have succeeded (%s)", err)
+	}
+	expected := []string{"WriteFile a", "CopyFile a", "ListDirPage "}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("Capabilities: expected %v to be observed, got %v", expected, ops)
+	}
+
+	basic := &observedBackend{next: struct{ backends.KvBackend }{backend}, observe: observed.observe}
+	if c := backends.CapabilitiesOf(basic); c != (backends.Capabilities{}) {
+		t.Errorf("Capabilities: expected no capabilities, got %+v", c)
+	}
+	err = basic.CopyFile("a", "c")
+	if err != backends.ErrNotSupported {
+		t.Errorf("Capabilities: expected ErrNotSupported, got %v", err)
+	}
+}
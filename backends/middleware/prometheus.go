@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histogram buckets
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts latencies in cumulative buckets, like a Prometheus histogram
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets, in seconds
+	Buckets []float64
+	// Counts are the number of observations less than or equal to each bucket
+	Counts []uint64
+	// Sum is the sum of all observations, in seconds
+	Sum float64
+}
+
+func NewHistogram(buckets []float64) Histogram {
+	return Histogram{Buckets: buckets, Counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(seconds float64) {
+	h.Sum += seconds
+	for i, bound := range h.Buckets {
+		if seconds <= bound {
+			h.Counts[i]++
+		}
+	}
+}
+
+// Copy returns a copy of h that doesn't change with h
+func (h Histogram) Copy() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// PrometheusWriter writes metrics in the Prometheus text exposition format. It keeps the first error,
+// so metrics can be written without checking every call.
+type PrometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func NewPrometheusWriter(w io.Writer) *PrometheusWriter {
+	return &PrometheusWriter{w: w}
+}
+
+// Header writes the HELP and TYPE lines of the metric name
+func (pw *PrometheusWriter) Header(name string, kind string, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Sample writes a sample of the metric name, labels being formatted by Labels
+func (pw *PrometheusWriter) Sample(name string, labels string, value uint64) {
+	if labels == "" {
+		pw.printf("%s %d\n", name, value)
+	} else {
+		pw.printf("%s{%s} %d\n", name, labels, value)
+	}
+}
+
+// Histogram writes the samples of h, which has count observations, as the histogram name
+func (pw *PrometheusWriter) Histogram(name string, labels string, h Histogram, count uint64) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+	for i, bound := range h.Buckets {
+		pw.printf("%s_bucket{%sle=\"%s\"} %d\n", name, prefix, strconv.FormatFloat(bound, 'g', -1, 64), h.Counts[i])
+	}
+	pw.printf("%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, count)
+	if labels == "" {
+		pw.printf("%s_sum %s\n%s_count %d\n", name, strconv.FormatFloat(h.Sum, 'g', -1, 64), name, count)
+	} else {
+		pw.printf("%s_sum{%s} %s\n%s_count{%s} %d\n", name, labels, strconv.FormatFloat(h.Sum, 'g', -1, 64), name, labels, count)
+	}
+}
+
+func (pw *PrometheusWriter) Err() error {
+	return pw.err
+}
+
+func (pw *PrometheusWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Labels formats pairs of label names and values, e.g. Labels("op", "Get") is op="Get"
+func Labels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records a span named after the operation (e.g. "multikv.backend.ReadFile") for every
+// operation, with the path, its prefix of depth prefixDepth, the number of bytes and the type of the
+// backend as attributes. Backends don't receive a context, so these spans are always root spans, see
+// TracingContext.
+func Tracing(tracer trace.Tracer, prefixDepth int) backends.Middleware {
+	return TracingContext(context.Background(), tracer, prefixDepth)
+}
+
+// TracingContext is like Tracing, but the spans are children of the span in ctx
+func TracingContext(ctx context.Context, tracer trace.Tracer, prefixDepth int) backends.Middleware {
+	if prefixDepth < 1 {
+		prefixDepth = 1
+	}
+	return func(next backends.KvBackend) backends.KvBackend {
+		backendType := fmt.Sprintf("%T", next)
+		return &observedBackend{
+			next: next,
+			observe: func(op string, path string, call func() (int, error)) error {
+				_, span := tracer.Start(ctx, "multikv.backend."+op, trace.WithSpanKind(trace.SpanKindClient))
+				defer span.End()
+				span.SetAttributes(
+					attribute.String("multikv.path", path),
+					attribute.String("multikv.prefix", Prefix(path, prefixDepth)),
+					attribute.String("multikv.backend", backendType),
+				)
+				n, err := call()
+				span.SetAttributes(attribute.Int("multikv.bytes", n))
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				return err
+			},
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	backend := backends.Chain(newTestBackend(t), Tracing(provider.Tracer("test"), 2))
+	err := backend.WriteFile("users/alice/data", []byte("test"))
+	if err != nil {
+		t.Fatalf("Tracing: WriteFile should have succeeded (%s)", err)
+	}
+	_, err = backend.ReadFile("users/bob/data")
+	if err == nil {
+		t.Fatalf("Tracing: ReadFile should have failed")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Tracing: expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "multikv.backend.WriteFile" || spans[1].Name() != "multikv.backend.ReadFile" {
+		t.Errorf("Tracing: unexpected span names %s and %s", spans[0].Name(), spans[1].Name())
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["multikv.prefix"].AsString() != "users/alice" || attrs["multikv.bytes"].AsInt64() != 4 {
+		t.Errorf("Tracing: unexpected span attributes %v", spans[0].Attributes())
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("Tracing: failed operation should have an error status, got %v", spans[1].Status())
+	}
+}
@@ -0,0 +1,276 @@
+// Package remote gives access to a backend served by another process over gRPC. Server fronts any
+// backends.KvBackend, and RemoteBackend is a backends.KvBackend that forwards every call to a Server:
+//
+//	// Server side
+//	s := grpc.NewServer()
+//	remote.Register(s, backend)
+//	s.Serve(listener)
+//
+//	// Client side
+//	conn, err := grpc.Dial("kv.internal:9090", grpc.WithTransportCredentials(creds))
+//	kv := multikv.KV{Backend: remote.NewRemoteBackend(conn, ctx)}
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/remote/remotepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type RemoteBackend struct {
+	client  remotepb.KvBackendClient
+	context context.Context
+	mu      sync.Mutex
+	// capabilities of the served backend, nil until the server answered
+	capabilities *backends.Capabilities
+}
+
+// NewRemoteBackend asks the server which capabilities the served backend supports. If it can't be
+// reached yet, it is asked again the next time they are needed.
+func NewRemoteBackend(conn grpc.ClientConnInterface, ctx context.Context) *RemoteBackend {
+	c := &RemoteBackend{
+		client:  remotepb.NewKvBackendClient(conn),
+		context: ctx,
+	}
+	c.Capabilities()
+	return c
+}
+
+// Capabilities reports the capabilities of the served backend. Until the server answers, and for
+// servers that can't tell, streaming and watching are assumed to be supported, and fail with
+// backends.ErrNotSupported if they aren't.
+func (c *RemoteBackend) Capabilities() backends.Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capabilities == nil {
+		resp, err := c.client.Capabilities(c.context, &remotepb.Empty{})
+		switch {
+		case status.Code(err) == codes.Unimplemented:
+			c.capabilities = &backends.Capabilities{Stream: true, Watch: true}
+		case err != nil:
+			return backends.Capabilities{Stream: true, Watch: true}
+		default:
+			c.capabilities = &backends.Capabilities{Stream: resp.Stream, Watch: resp.Watch}
+		}
+	}
+	return *c.capabilities
+}
+
+// fromStatus maps gRPC status codes back to the errors callers check for. Missing files are
+// reported like the local backend does, so os.IsNotExist works on them.
+func fromStatus(op string, path string, err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	case codes.FailedPrecondition:
+		return backends.ErrPreconditionFailed
+	case codes.Unimplemented:
+		return backends.ErrNotSupported
+	case codes.PermissionDenied:
+		return wrapStatus(auth.ErrPermissionDenied, err)
+	case codes.Unauthenticated:
+		return wrapStatus(auth.ErrUnauthenticated, err)
+	}
+	return err
+}
+
+// wrapStatus returns the message of the status err wrapping target, which it usually starts with
+func wrapStatus(target error, err error) error {
+	message := status.Convert(err).Message()
+	if strings.HasPrefix(message, target.Error()) {
+		return fmt.Errorf("%w%s", target, strings.TrimPrefix(message, target.Error()))
+	}
+	return fmt.Errorf("%w: %s", target, message)
+}
+
+func (c *RemoteBackend) Exist(path string) (bool, error) {
+	resp, err := c.client.Exist(c.context, &remotepb.PathRequest{Path: path})
+	if err != nil {
+		return false, fromStatus("stat", path, err)
+	}
+	return resp.Found, nil
+}
+
+func (c *RemoteBackend) ListDir(path string) ([]string, error) {
+	resp, err := c.client.ListDir(c.context, &remotepb.PathRequest{Path: path})
+	if err != nil {
+		return nil, fromStatus("readdir", path, err)
+	}
+	return resp.Names, nil
+}
+
+func (c *RemoteBackend) DeleteDir(path string) error {
+	_, err := c.client.DeleteDir(c.context, &remotepb.PathRequest{Path: path})
+	return fromStatus("remove", path, err)
+}
+
+func (c *RemoteBackend) DeleteFile(path string) error {
+	_, err := c.client.DeleteFile(c.context, &remotepb.PathRequest{Path: path})
+	return fromStatus("remove", path, err)
+}
+
+// ReadFile streams the file, since its size isn't known in advance and gRPC messages are limited to
+// 4 MiB by default
+func (c *RemoteBackend) ReadFile(path string) ([]byte, error) {
+	r, err := c.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// WriteFile streams files larger than a chunk, for the same reason
+func (c *RemoteBackend) WriteFile(path string, data []byte) error {
+	if len(data) <= chunkSize {
+		_, err := c.client.WriteFile(c.context, &remotepb.WriteFileRequest{Path: path, Data: data})
+		return fromStatus("write", path, err)
+	}
+	w, err := c.OpenWriter(path)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		// Cancels the upload, the server never stores partial files
+		w.(*writer).cancel()
+		return err
+	}
+	return w.Close()
+}
+
+func (c *RemoteBackend) OpenReader(path string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(c.context)
+	stream, err := c.client.OpenReader(ctx, &remotepb.PathRequest{Path: path})
+	if err != nil {
+		cancel()
+		return nil, fromStatus("open", path, err)
+	}
+	// The server always sends a first chunk once the file is open, so missing files fail here
+	chunk, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, fromStatus("open", path, err)
+	}
+	return &reader{stream: stream, cancel: cancel, path: path, buf: chunk.Data}, nil
+}
+
+type reader struct {
+	stream remotepb.KvBackend_OpenReaderClient
+	cancel context.CancelFunc
+	path   string
+	buf    []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fromStatus("read", r.path, err)
+		}
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// OpenWriter streams to the server, which only stores the file once the writer is closed
+func (c *RemoteBackend) OpenWriter(path string) (io.WriteCloser, error) {
+	ctx, cancel := context.WithCancel(c.context)
+	stream, err := c.client.OpenWriter(ctx)
+	if err != nil {
+		cancel()
+		return nil, fromStatus("open", path, err)
+	}
+	err = stream.Send(&remotepb.WriteChunk{Path: path})
+	if err != nil {
+		cancel()
+		return nil, fromStatus("open", path, err)
+	}
+	return &writer{stream: stream, cancel: cancel, path: path}, nil
+}
+
+type writer struct {
+	stream remotepb.KvBackend_OpenWriterClient
+	// cancel aborts the upload
+	cancel context.CancelFunc
+	path   string
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		err := w.stream.Send(&remotepb.WriteChunk{Data: p[:n]})
+		if err != nil {
+			return written, fromStatus("write", w.path, err)
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	defer w.cancel()
+	_, err := w.stream.CloseAndRecv()
+	return fromStatus("write", w.path, err)
+}
+
+// Watch fails with backends.ErrNotSupported if the served backend isn't a backends.Watcher
+func (c *RemoteBackend) Watch(ctx context.Context, path string) (<-chan backends.Event, error) {
+	stream, err := c.client.Watch(ctx, &remotepb.PathRequest{Path: path})
+	if err != nil {
+		return nil, fromStatus("watch", path, err)
+	}
+	// The first event only tells that the watch is set up
+	_, err = stream.Recv()
+	if err != nil {
+		return nil, fromStatus("watch", path, err)
+	}
+	events := make(chan backends.Event)
+	go func() {
+		defer close(events)
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			eventType := backends.EventWrite
+			if event.Type == remotepb.Event_TYPE_DELETE {
+				eventType = backends.EventDelete
+			}
+			select {
+			case events <- backends.Event{Type: eventType, Path: event.Path}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
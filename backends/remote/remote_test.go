@@ -0,0 +1,234 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
+	"github.com/marcelocarlos/multikv/backends/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestBackend serves served over an in-process connection, and returns a client for it
+func newTestBackend(t *testing.T, served backends.KvBackend) *RemoteBackend {
+	s := grpc.NewServer()
+	Register(s, served)
+	return NewRemoteBackend(serve(t, s), context.Background())
+}
+
+// serve starts s on an in-process listener and connects to it
+func serve(t *testing.T, s *grpc.Server, opts ...grpc.DialOption) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	opts = append(opts, grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	conn, err := grpc.Dial("bufconn", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+	})
+	return conn
+}
+
+func newLocalBackend(t *testing.T) local.LocalBackend {
+	dir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	backend, err := local.NewLocalBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+		return newTestBackend(t, newLocalBackend(t))
+	})
+}
+
+func TestReadFile_NotFound(t *testing.T) {
+	backend := newTestBackend(t, newLocalBackend(t))
+	_, err := backend.ReadFile("missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadFile: expected a not exist error, got %v", err)
+	}
+	_, err = backend.OpenReader("missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("OpenReader: expected a not exist error, got %v", err)
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	backend := newTestBackend(t, newLocalBackend(t))
+	// Several chunks
+	contents := bytes.Repeat([]byte("0123456789"), chunkSize/4)
+	w, err := backend.OpenWriter("test/key")
+	if err != nil {
+		t.Fatalf("OpenWriter: should not have failed (%s)", err)
+	}
+	_, err = w.Write(contents)
+	if err != nil {
+		t.Fatalf("OpenWriter: Write should not have failed (%s)", err)
+	}
+	found, _ := backend.Exist("test/key")
+	if found {
+		t.Errorf("OpenWriter: file should not exist before Close")
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("OpenWriter: Close should not have failed (%s)", err)
+	}
+
+	r, err := backend.OpenReader("test/key")
+	if err != nil {
+		t.Fatalf("OpenReader: should not have failed (%s)", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Errorf("OpenReader: ReadAll should not have failed (%s)", err)
+	}
+	if !bytes.Equal(data, contents) {
+		t.Errorf("OpenReader: read %d bytes different from the %d written", len(data), len(contents))
+	}
+}
+
+// basicBackend hides the optional capabilities of the backend it wraps
+type basicBackend struct {
+	backends.KvBackend
+}
+
+func TestWatch(t *testing.T) {
+	served := newLocalBackend(t)
+	served.WatchInterval = 10 * time.Millisecond
+	backend := newTestBackend(t, served)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := backend.Watch(ctx, "dir")
+	if err != nil {
+		t.Fatalf("Watch: should have succeeded (%s)", err)
+	}
+	err = backend.WriteFile("dir/new", []byte("test"))
+	if err != nil {
+		t.Fatalf("Watch: failed to write file (%s)", err)
+	}
+	select {
+	case event := <-events:
+		expected := backends.Event{Type: backends.EventWrite, Path: "dir/new"}
+		if event != expected {
+			t.Errorf("Watch: expected %+v, got %+v", expected, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Watch: timed out waiting for an event")
+	}
+
+	_, err = newTestBackend(t, basicBackend{served}).Watch(ctx, "dir")
+	if err != backends.ErrNotSupported {
+		t.Errorf("Watch: expected ErrNotSupported for a backend that can't watch, got %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	served := newLocalBackend(t)
+	tests := []struct {
+		served   backends.KvBackend
+		expected backends.Capabilities
+	}{
+		{served, backends.Capabilities{Stream: true, Watch: true}},
+		{basicBackend{served}, backends.Capabilities{}},
+	}
+	for _, test := range tests {
+		found := backends.CapabilitiesOf(newTestBackend(t, test.served))
+		if found != test.expected {
+			t.Errorf("Capabilities: expected %+v for %T, got %+v", test.expected, test.served, found)
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	served := newLocalBackend(t)
+	err := served.WriteFile("secrets/db/data", []byte("test"))
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	authenticator := auth.StaticTokens{"alice": "alice-token", "bob": "bob-token"}
+	s := grpc.NewServer(grpc.UnaryInterceptor(auth.UnaryServerInterceptor(authenticator)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(authenticator)))
+	Register(s, served, WithAuthorizer(&auth.Authorizer{Policy: &auth.Policy{Rules: []auth.Rule{
+		{Principals: []string{"alice"}, Prefix: "secrets", Permissions: []auth.Permission{auth.Read, auth.Write, auth.List}},
+		{Principals: []string{"*"}, Prefix: "public", Permissions: []auth.Permission{auth.Read}},
+	}}}))
+	alice := NewRemoteBackend(serve(t, s, grpc.WithPerRPCCredentials(auth.TokenCredentials{Token: "alice-token", AllowInsecure: true})), context.Background())
+	_, err = alice.ReadFile("secrets/db/data")
+	if err != nil {
+		t.Errorf("ReadFile: alice should be able to read secrets (%s)", err)
+	}
+	r, err := alice.OpenReader("secrets/db/data")
+	if err != nil {
+		t.Errorf("OpenReader: alice should be able to stream secrets (%s)", err)
+	} else {
+		r.Close()
+	}
+	_, err = alice.ListDir("")
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("ListDir: alice should not be able to list the root, got %v", err)
+	}
+
+	s = grpc.NewServer(grpc.UnaryInterceptor(auth.UnaryServerInterceptor(authenticator)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(authenticator)))
+	Register(s, served, WithAuthorizer(&auth.Authorizer{Policy: &auth.Policy{}}))
+	bob := NewRemoteBackend(serve(t, s, grpc.WithPerRPCCredentials(auth.TokenCredentials{Token: "bob-token", AllowInsecure: true})), context.Background())
+	_, err = bob.ReadFile("secrets/db/data")
+	if !errors.Is(err, auth.ErrPermissionDenied) || err.Error() != "permission denied: bob cannot read secrets/db/data" {
+		t.Errorf("ReadFile: bob should not be able to read secrets, got %v", err)
+	}
+	w, err := bob.OpenWriter("secrets/db/data")
+	if err == nil {
+		_, _ = w.Write([]byte("overwritten"))
+		err = w.Close()
+	}
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("OpenWriter: bob should not be able to write secrets, got %v", err)
+	}
+
+	anonymous := NewRemoteBackend(serve(t, s), context.Background())
+	_, err = anonymous.Exist("public/a")
+	if !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("Exist: calls without a token should have been rejected, got %v", err)
+	}
+}
+
+func TestLargeFiles(t *testing.T) {
+	backend := newTestBackend(t, newLocalBackend(t))
+	// Larger than the 4 MiB gRPC messages are limited to by default
+	contents := bytes.Repeat([]byte("0123456789"), 600<<10)
+	err := backend.WriteFile("test/key", contents)
+	if err != nil {
+		t.Fatalf("WriteFile: should not have failed (%s)", err)
+	}
+	data, err := backend.ReadFile("test/key")
+	if err != nil {
+		t.Fatalf("ReadFile: should not have failed (%s)", err)
+	}
+	if !bytes.Equal(data, contents) {
+		t.Errorf("ReadFile: read %d bytes different from the %d written", len(data), len(contents))
+	}
+}
@@ -0,0 +1,4 @@
+// Package remotepb contains the gRPC API of backends/remote, generated from remote.proto
+package remotepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative remote.proto
@@ -0,0 +1,524 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package remotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// KvBackendClient is the client API for KvBackend service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KvBackendClient interface {
+	Exist(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*ExistResponse, error)
+	ListDir(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*ListDirResponse, error)
+	DeleteDir(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*Empty, error)
+	DeleteFile(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*Empty, error)
+	ReadFile(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*ReadFileResponse, error)
+	WriteFile(ctx context.Context, in *WriteFileRequest, opts ...grpc.CallOption) (*Empty, error)
+	// OpenReader streams the contents of a file in chunks
+	OpenReader(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (KvBackend_OpenReaderClient, error)
+	// OpenWriter stores the chunks it receives in a file once the stream is closed. The path is set in
+	// the first message only.
+	OpenWriter(ctx context.Context, opts ...grpc.CallOption) (KvBackend_OpenWriterClient, error)
+	// Watch sends an event with no type once the watch is set up, then an event for every change
+	Watch(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (KvBackend_WatchClient, error)
+	// Capabilities reports which of the optional capabilities above the served backend supports
+	Capabilities(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error)
+}
+
+type kvBackendClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKvBackendClient(cc grpc.ClientConnInterface) KvBackendClient {
+	return &kvBackendClient{cc}
+}
+
+func (c *kvBackendClient) Exist(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*ExistResponse, error) {
+	out := new(ExistResponse)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/Exist", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kvBackendClient) ListDir(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*ListDirResponse, error) {
+	out := new(ListDirResponse)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/ListDir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kvBackendClient) DeleteDir(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/DeleteDir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kvBackendClient) DeleteFile(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/DeleteFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kvBackendClient) ReadFile(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (*ReadFileResponse, error) {
+	out := new(ReadFileResponse)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/ReadFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kvBackendClient) WriteFile(ctx context.Context, in *WriteFileRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/WriteFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kvBackendClient) OpenReader(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (KvBackend_OpenReaderClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KvBackend_ServiceDesc.Streams[0], "/multikv.remote.v1.KvBackend/OpenReader", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kvBackendOpenReaderClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KvBackend_OpenReaderClient interface {
+	Recv() (*Chunk, error)
+	grpc.ClientStream
+}
+
+type kvBackendOpenReaderClient struct {
+	grpc.ClientStream
+}
+
+func (x *kvBackendOpenReaderClient) Recv() (*Chunk, error) {
+	m := new(Chunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kvBackendClient) OpenWriter(ctx context.Context, opts ...grpc.CallOption) (KvBackend_OpenWriterClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KvBackend_ServiceDesc.Streams[1], "/multikv.remote.v1.KvBackend/OpenWriter", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kvBackendOpenWriterClient{stream}
+	return x, nil
+}
+
+type KvBackend_OpenWriterClient interface {
+	Send(*WriteChunk) error
+	CloseAndRecv() (*Empty, error)
+	grpc.ClientStream
+}
+
+type kvBackendOpenWriterClient struct {
+	grpc.ClientStream
+}
+
+func (x *kvBackendOpenWriterClient) Send(m *WriteChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *kvBackendOpenWriterClient) CloseAndRecv() (*Empty, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kvBackendClient) Watch(ctx context.Context, in *PathRequest, opts ...grpc.CallOption) (KvBackend_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KvBackend_ServiceDesc.Streams[2], "/multikv.remote.v1.KvBackend/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kvBackendWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KvBackend_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type kvBackendWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *kvBackendWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kvBackendClient) Capabilities(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error) {
+	out := new(CapabilitiesResponse)
+	err := c.cc.Invoke(ctx, "/multikv.remote.v1.KvBackend/Capabilities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KvBackendServer is the server API for KvBackend service.
+// All implementations must embed UnimplementedKvBackendServer
+// for forward compatibility
+type KvBackendServer interface {
+	Exist(context.Context, *PathRequest) (*ExistResponse, error)
+	ListDir(context.Context, *PathRequest) (*ListDirResponse, error)
+	DeleteDir(context.Context, *PathRequest) (*Empty, error)
+	DeleteFile(context.Context, *PathRequest) (*Empty, error)
+	ReadFile(context.Context, *PathRequest) (*ReadFileResponse, error)
+	WriteFile(context.Context, *WriteFileRequest) (*Empty, error)
+	// OpenReader streams the contents of a file in chunks
+	OpenReader(*PathRequest, KvBackend_OpenReaderServer) error
+	// OpenWriter stores the chunks it receives in a file once the stream is closed. The path is set in
+	// the first message only.
+	OpenWriter(KvBackend_OpenWriterServer) error
+	// Watch sends an event with no type once the watch is set up, then an event for every change
+	Watch(*PathRequest, KvBackend_WatchServer) error
+	// Capabilities reports which of the optional capabilities above the served backend supports
+	Capabilities(context.Context, *Empty) (*CapabilitiesResponse, error)
+	mustEmbedUnimplementedKvBackendServer()
+}
+
+// UnimplementedKvBackendServer must be embedded to have forward compatible implementations.
+type UnimplementedKvBackendServer struct {
+}
+
+func (UnimplementedKvBackendServer) Exist(context.Context, *PathRequest) (*ExistResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Exist not implemented")
+}
+func (UnimplementedKvBackendServer) ListDir(context.Context, *PathRequest) (*ListDirResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDir not implemented")
+}
+func (UnimplementedKvBackendServer) DeleteDir(context.Context, *PathRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDir not implemented")
+}
+func (UnimplementedKvBackendServer) DeleteFile(context.Context, *PathRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedKvBackendServer) ReadFile(context.Context, *PathRequest) (*ReadFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadFile not implemented")
+}
+func (UnimplementedKvBackendServer) WriteFile(context.Context, *WriteFileRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteFile not implemented")
+}
+func (UnimplementedKvBackendServer) OpenReader(*PathRequest, KvBackend_OpenReaderServer) error {
+	return status.Errorf(codes.Unimplemented, "method OpenReader not implemented")
+}
+func (UnimplementedKvBackendServer) OpenWriter(KvBackend_OpenWriterServer) error {
+	return status.Errorf(codes.Unimplemented, "method OpenWriter not implemented")
+}
+func (UnimplementedKvBackendServer) Watch(*PathRequest, KvBackend_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedKvBackendServer) Capabilities(context.Context, *Empty) (*CapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Capabilities not implemented")
+}
+func (UnimplementedKvBackendServer) mustEmbedUnimplementedKvBackendServer() {}
+
+// UnsafeKvBackendServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KvBackendServer will
+// result in compilation errors.
+type UnsafeKvBackendServer interface {
+	mustEmbedUnimplementedKvBackendServer()
+}
+
+func RegisterKvBackendServer(s grpc.ServiceRegistrar, srv KvBackendServer) {
+	s.RegisterService(&KvBackend_ServiceDesc, srv)
+}
+
+func _KvBackend_Exist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).Exist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/Exist",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).Exist(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KvBackend_ListDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).ListDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/ListDir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).ListDir(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KvBackend_DeleteDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).DeleteDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/DeleteDir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).DeleteDir(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KvBackend_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/DeleteFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).DeleteFile(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KvBackend_ReadFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PathRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).ReadFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/ReadFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).ReadFile(ctx, req.(*PathRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KvBackend_WriteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).WriteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/WriteFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).WriteFile(ctx, req.(*WriteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KvBackend_OpenReader_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PathRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KvBackendServer).OpenReader(m, &kvBackendOpenReaderServer{stream})
+}
+
+type KvBackend_OpenReaderServer interface {
+	Send(*Chunk) error
+	grpc.ServerStream
+}
+
+type kvBackendOpenReaderServer struct {
+	grpc.ServerStream
+}
+
+func (x *kvBackendOpenReaderServer) Send(m *Chunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KvBackend_OpenWriter_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KvBackendServer).OpenWriter(&kvBackendOpenWriterServer{stream})
+}
+
+type KvBackend_OpenWriterServer interface {
+	SendAndClose(*Empty) error
+	Recv() (*WriteChunk, error)
+	grpc.ServerStream
+}
+
+type kvBackendOpenWriterServer struct {
+	grpc.ServerStream
+}
+
+func (x *kvBackendOpenWriterServer) SendAndClose(m *Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *kvBackendOpenWriterServer) Recv() (*WriteChunk, error) {
+	m := new(WriteChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KvBackend_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PathRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KvBackendServer).Watch(m, &kvBackendWatchServer{stream})
+}
+
+type KvBackend_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type kvBackendWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *kvBackendWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KvBackend_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KvBackendServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/multikv.remote.v1.KvBackend/Capabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KvBackendServer).Capabilities(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// KvBackend_ServiceDesc is the grpc.ServiceDesc for KvBackend service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KvBackend_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "multikv.remote.v1.KvBackend",
+	HandlerType: (*KvBackendServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Exist",
+			Handler:    _KvBackend_Exist_Handler,
+		},
+		{
+			MethodName: "ListDir",
+			Handler:    _KvBackend_ListDir_Handler,
+		},
+		{
+			MethodName: "DeleteDir",
+			Handler:    _KvBackend_DeleteDir_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _KvBackend_DeleteFile_Handler,
+		},
+		{
+			MethodName: "ReadFile",
+			Handler:    _KvBackend_ReadFile_Handler,
+		},
+		{
+			MethodName: "WriteFile",
+			Handler:    _KvBackend_WriteFile_Handler,
+		},
+		{
+			MethodName: "Capabilities",
+			Handler:    _KvBackend_Capabilities_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "OpenReader",
+			Handler:       _KvBackend_OpenReader_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "OpenWriter",
+			Handler:       _KvBackend_OpenWriter_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _KvBackend_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "remote.proto",
+}
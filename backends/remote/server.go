@@ -0,0 +1,272 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/remote/remotepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chunkSize is the size of the chunks files are streamed in
+const chunkSize = 64 << 10
+
+// Server serves a backend over gRPC, to be used by RemoteBackend clients
+type Server struct {
+	remotepb.UnimplementedKvBackendServer
+	backend    backends.KvBackend
+	authorizer *auth.Authorizer
+}
+
+type Option func(*Server)
+
+// WithAuthorizer checks every call against the policy of authorizer, as the principal set by the
+// auth interceptors (see auth.UnaryServerInterceptor). Calls are checked on the files of the
+// backend, so writing a key through a RemoteBackend also needs the permission to read it, to update
+// its info file.
+func WithAuthorizer(authorizer *auth.Authorizer) Option {
+	return func(s *Server) {
+		s.authorizer = authorizer
+	}
+}
+
+func NewServer(backend backends.KvBackend, opts ...Option) *Server {
+	s := &Server{backend: backend}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register registers a Server for backend with s
+func Register(s *grpc.Server, backend backends.KvBackend, opts ...Option) {
+	remotepb.RegisterKvBackendServer(s, NewServer(backend, opts...))
+}
+
+func (s *Server) authorize(ctx context.Context, perm auth.Permission, op string, path string) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	return toStatus(s.authorizer.Authorize(ctx, perm, op, path))
+}
+
+// toStatus maps the errors clients check for to gRPC status codes
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, backends.ErrPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, backends.ErrNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+func (s *Server) Exist(ctx context.Context, req *remotepb.PathRequest) (*remotepb.ExistResponse, error) {
+	err := s.authorize(ctx, auth.Read, "Exist", req.Path)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.backend.Exist(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &remotepb.ExistResponse{Found: found}, nil
+}
+
+func (s *Server) ListDir(ctx context.Context, req *remotepb.PathRequest) (*remotepb.ListDirResponse, error) {
+	err := s.authorize(ctx, auth.List, "ListDir", req.Path)
+	if err != nil {
+		return nil, err
+	}
+	names, err := s.backend.ListDir(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &remotepb.ListDirResponse{Names: names}, nil
+}
+
+func (s *Server) DeleteDir(ctx context.Context, req *remotepb.PathRequest) (*remotepb.Empty, error) {
+	err := s.authorize(ctx, auth.Write, "DeleteDir", req.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.DeleteDir(req.Path))
+}
+
+func (s *Server) DeleteFile(ctx context.Context, req *remotepb.PathRequest) (*remotepb.Empty, error) {
+	err := s.authorize(ctx, auth.Write, "DeleteFile", req.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.DeleteFile(req.Path))
+}
+
+func (s *Server) ReadFile(ctx context.Context, req *remotepb.PathRequest) (*remotepb.ReadFileResponse, error) {
+	err := s.authorize(ctx, auth.Read, "ReadFile", req.Path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.backend.ReadFile(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &remotepb.ReadFileResponse{Data: data}, nil
+}
+
+func (s *Server) WriteFile(ctx context.Context, req *remotepb.WriteFileRequest) (*remotepb.Empty, error) {
+	err := s.authorize(ctx, auth.Write, "WriteFile", req.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &remotepb.Empty{}, toStatus(s.backend.WriteFile(req.Path, req.Data))
+}
+
+// OpenReader streams from the backend if it is a backends.Streamer, and reads the whole file first otherwise
+func (s *Server) OpenReader(req *remotepb.PathRequest, stream remotepb.KvBackend_OpenReaderServer) error {
+	err := s.authorize(stream.Context(), auth.Read, "OpenReader", req.Path)
+	if err != nil {
+		return err
+	}
+	var r io.ReadCloser
+	if streamer, ok := s.backend.(backends.Streamer); ok && backends.CapabilitiesOf(s.backend).Stream {
+		r, err = streamer.OpenReader(req.Path)
+	} else {
+		var data []byte
+		data, err = s.backend.ReadFile(req.Path)
+		r = ioutil.NopCloser(bytes.NewReader(data))
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	defer r.Close()
+	buf := make([]byte, chunkSize)
+	// An empty file is still sent as one chunk, which tells the client the file was opened
+	sent := false
+	for {
+		n, err := r.Read(buf)
+		if n > 0 || (err == io.EOF && !sent) {
+			sendErr := stream.Send(&remotepb.Chunk{Data: buf[:n]})
+			if sendErr != nil {
+				return sendErr
+			}
+			sent = true
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// OpenWriter spools the file to a temporary file until the client closes the stream, so that an
+// interrupted upload never reaches the backend. It is then streamed to the backend if it is a
+// backends.Streamer, and read back in memory otherwise.
+func (s *Server) OpenWriter(stream remotepb.KvBackend_OpenWriterServer) error {
+	chunk, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	path := chunk.Path
+	err = s.authorize(stream.Context(), auth.Write, "OpenWriter", path)
+	if err != nil {
+		return err
+	}
+	spool, err := ioutil.TempFile("", "multikv-remote-")
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create temporary file (%s)", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	for err == nil {
+		_, err = spool.Write(chunk.Data)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to write temporary file (%s)", err)
+		}
+		chunk, err = stream.Recv()
+	}
+	if err != io.EOF {
+		return err
+	}
+	_, err = spool.Seek(0, io.SeekStart)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read temporary file (%s)", err)
+	}
+
+	if streamer, ok := s.backend.(backends.Streamer); ok && backends.CapabilitiesOf(s.backend).Stream {
+		w, err := streamer.OpenWriter(path)
+		if err != nil {
+			return toStatus(err)
+		}
+		_, err = io.Copy(w, spool)
+		if err != nil {
+			w.Close()
+			return toStatus(err)
+		}
+		err = w.Close()
+		if err != nil {
+			return toStatus(err)
+		}
+	} else {
+		data, err := ioutil.ReadAll(spool)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read temporary file (%s)", err)
+		}
+		err = s.backend.WriteFile(path, data)
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+	return stream.SendAndClose(&remotepb.Empty{})
+}
+
+// Capabilities reports the capabilities of the served backend that RemoteBackend forwards
+func (s *Server) Capabilities(ctx context.Context, req *remotepb.Empty) (*remotepb.CapabilitiesResponse, error) {
+	c := backends.CapabilitiesOf(s.backend)
+	return &remotepb.CapabilitiesResponse{Stream: c.Stream, Watch: c.Watch}, nil
+}
+
+func (s *Server) Watch(req *remotepb.PathRequest, stream remotepb.KvBackend_WatchServer) error {
+	err := s.authorize(stream.Context(), auth.List, "Watch", req.Path)
+	if err != nil {
+		return err
+	}
+	watcher, ok := s.backend.(backends.Watcher)
+	if !ok || !backends.CapabilitiesOf(s.backend).Watch {
+		return toStatus(backends.ErrNotSupported)
+	}
+	events, err := watcher.Watch(stream.Context(), req.Path)
+	if err != nil {
+		return toStatus(err)
+	}
+	err = stream.Send(&remotepb.Event{})
+	if err != nil {
+		return err
+	}
+	for event := range events {
+		eventType := remotepb.Event_TYPE_WRITE
+		if event.Type == backends.EventDelete {
+			eventType = remotepb.Event_TYPE_DELETE
+		}
+		err = stream.Send(&remotepb.Event{Type: eventType, Path: event.Path})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package replicated
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+const (
+	OpWrite      = "write"
+	OpDeleteFile = "deleteFile"
+	OpDeleteDir  = "deleteDir"
+)
+
+// Failure records a change that didn't reach one of the replicas
+type Failure struct {
+	Op      string
+	Path    string
+	Replica int
+	Err     error
+	Time    time.Time
+}
+
+// ReplicatedBackend mirrors every change to all of its replicas and succeeds as long as at least
+// quorum of them acknowledged it. Reads are served by the primary (the first replica) and fall back
+// to the next replicas in order if it fails. Replicas with a pending failure for a path are skipped
+// when reading it, so that reads never see a change that was missed.
+type ReplicatedBackend struct {
+	replicas []backends.KvBackend
+	quorum   int
+	mu       sync.Mutex
+	failures map[failureKey]Failure
+}
+
+type failureKey struct {
+	replica int
+	path    string
+}
+
+func NewReplicatedBackend(quorum int, primary backends.KvBackend, secondaries ...backends.KvBackend) (*ReplicatedBackend, error) {
+	replicas := append([]backends.KvBackend{primary}, secondaries...)
+	if quorum < 1 || quorum > len(replicas) {
+		return nil, fmt.Errorf("invalid quorum %d for %d replicas", quorum, len(replicas))
+	}
+	return &ReplicatedBackend{
+		replicas: replicas,
+		quorum:   quorum,
+		failures: make(map[failureKey]Failure),
+	}, nil
+}
+
+func (c *ReplicatedBackend) WriteFile(path string, value []byte) error {
+	return c.fanOut(OpWrite, path, func(replica backends.KvBackend) error {
+		return replica.WriteFile(path, value)
+	})
+}
+
+func (c *ReplicatedBackend) DeleteFile(path string) error {
+	// A replica that doesn't have the file (e.g. because it missed the write that created it) is
+	// already in sync, but the delete still fails like on any backend if no replica had the file
+	var mu sync.Mutex
+	var missingErr error
+	missing := 0
+	err := c.fanOut(OpDeleteFile, path, func(replica backends.KvBackend) error {
+		err := replica.DeleteFile(path)
+		if isMissing(replica, path, err) {
+			mu.Lock()
+			missing++
+			missingErr = err
+			mu.Unlock()
+			return nil
+		}
+		return err
+	})
+	if err == nil && missing == len(c.replicas) {
+		return missingErr
+	}
+	return err
+}
+
+func (c *ReplicatedBackend) DeleteDir(path string) error {
+	return c.fanOut(OpDeleteDir, path, func(replica backends.KvBackend) error {
+		return replica.DeleteDir(path)
+	})
+}
+
+func (c *ReplicatedBackend) ReadFile(path string) ([]byte, error) {
+	var data []byte
+	err := c.readInSync(path, func(replica backends.KvBackend) error {
+		var err error
+		data, err = replica.ReadFile(path)
+		return err
+	})
+	return data, err
+}
+
+func (c *ReplicatedBackend) ListDir(path string) ([]string, error) {
+	var files []string
+	err := c.readInSync(path, func(replica backends.KvBackend) error {
+		var err error
+		files, err = replica.ListDir(path)
+		return err
+	})
+	return files, err
+}
+
+func (c *ReplicatedBackend) Exist(path string) (bool, error) {
+	var found bool
+	err := c.readInSync(path, func(replica backends.KvBackend) error {
+		var err error
+		found, err = replica.Exist(path)
+		return err
+	})
+	return found, err
+}
+
+// Failures returns the changes that are still missing from some replica, oldest first
+func (c *ReplicatedBackend) Failures() []Failure {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failures := make([]Failure, 0, len(c.failures))
+	for _, f := range c.failures {
+		failures = append(failures, f)
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Time.Before(failures[j].Time) })
+	return failures
+}
+
+// Retry applies the recorded failures again. Failed writes are retried with the current contents of
+// the path, read from a replica that is in sync. Failures that still can't be applied are kept.
+func (c *ReplicatedBackend) Retry() error {
+	var lastErr error
+	for _, f := range c.Failures() {
+		replica := c.replicas[f.Replica]
+		var err error
+		switch f.Op {
+		case OpWrite:
+			var data []byte
+			err = c.readInSync(f.Path, func(replica backends.KvBackend) error {
+				var err error
+				data, err = replica.ReadFile(f.Path)
+				return err
+			})
+			if err == nil {
+				err = replica.WriteFile(f.Path, data)
+			}
+		case OpDeleteFile:
+			err = replica.DeleteFile(f.Path)
+			if isMissing(replica, f.Path, err) {
+				err = nil
+			}
+		case OpDeleteDir:
+			err = replica.DeleteDir(f.Path)
+		}
+		c.mu.Lock()
+		key := failureKey{replica: f.Replica, path: f.Path}
+		// Don't touch the record if a newer change to the same path failed in the meantime
+		if current, ok := c.failures[key]; ok && current.Time.Equal(f.Time) {
+			if err == nil {
+				delete(c.failures, key)
+			} else {
+				current.Err = err
+				c.failures[key] = current
+			}
+		}
+		c.mu.Unlock()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to retry %s of %s on replica %d (%s)", f.Op, f.Path, f.Replica, err)
+		}
+	}
+	return lastErr
+}
+
+func (c *ReplicatedBackend) fanOut(op string, path string, fn func(replica backends.KvBackend) error) error {
+	errs := make([]error, len(c.replicas))
+	var wg sync.WaitGroup
+	for i, replica := range c.replicas {
+		wg.Add(1)
+		go func(i int, replica backends.KvBackend) {
+			defer wg.Done()
+			errs[i] = fn(replica)
+		}(i, replica)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	var firstErr error
+	now := time.Now()
+	c.mu.Lock()
+	for i, err := range errs {
+		key := failureKey{replica: i, path: path}
+		if err == nil {
+			succeeded++
+			// The replica is in sync again for this path
+			delete(c.failures, key)
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		c.failures[key] = Failure{Op: op, Path: path, Replica: i, Err: err, Time: now}
+	}
+	c.mu.Unlock()
+	if succeeded < c.quorum {
+		return fmt.Errorf("%s of %s reached %d of %d replicas, quorum is %d (%s)", op, path, succeeded, len(c.replicas), c.quorum, firstErr)
+	}
+	return nil
+}
+
+// isMissing reports whether err is a failure to delete path because replica doesn't have it
+func isMissing(replica backends.KvBackend, path string, err error) bool {
+	if err == nil {
+		return false
+	}
+	found, existErr := replica.Exist(path)
+	return existErr == nil && !found
+}
+
+// readInSync calls read with the replicas that are in sync for path, in order, until one succeeds
+func (c *ReplicatedBackend) readInSync(path string, read func(replica backends.KvBackend) error) error {
+	err := fmt.Errorf("no replica is in sync for %s", path)
+	for i, replica := range c.replicas {
+		if !c.inSync(i, path) {
+			continue
+		}
+		err = read(replica)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// inSync reports whether replica has no pending failure for path, one of its parents (a deleted
+// directory) or one of its children (which a listing of path would show)
+func (c *ReplicatedBackend) inSync(replica int, path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.failures {
+		if key.replica == replica && (key.path == path || isParent(key.path, path) || isParent(path, key.path)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isParent(parent string, path string) bool {
+	parent = strings.Trim(parent, "/")
+	return parent == "" || strings.HasPrefix(strings.Trim(path, "/"), parent+"/")
+}
@@ -0,0 +1,281 @@
+package replicated
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+var errUnreachable = errors.New("replica unreachable")
+
+// flakyBackend simulates a replica that can go offline
+type flakyBackend struct {
+	backends.KvBackend
+	down bool
+}
+
+func (c *flakyBackend) WriteFile(path string, value []byte) error {
+	if c.down {
+		return errUnreachable
+	}
+	return c.KvBackend.WriteFile(path, value)
+}
+
+func (c *flakyBackend) ReadFile(path string) ([]byte, error) {
+	if c.down {
+		return nil, errUnreachable
+	}
+	return c.KvBackend.ReadFile(path)
+}
+
+func (c *flakyBackend) DeleteFile(path string) error {
+	if c.down {
+		return errUnreachable
+	}
+	return c.KvBackend.DeleteFile(path)
+}
+
+func newTestReplicas(t *testing.T, n int) ([]*flakyBackend, func()) {
+	var dirs []string
+	cleanup := func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+	var replicas []*flakyBackend
+	for i := 0; i < n; i++ {
+		dir, err := ioutil.TempDir("", "multikv-test-dir")
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+		backend, err := local.NewLocalBackend(dir)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		replicas = append(replicas, &flakyBackend{KvBackend: backend})
+	}
+	return replicas, cleanup
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+		replicas, cleanup := newTestReplicas(t, 2)
+		t.Cleanup(cleanup)
+		backend, err := NewReplicatedBackend(2, replicas[0], replicas[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	})
+}
+
+func TestNewReplicatedBackend_InvalidQuorum(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 2)
+	defer cleanup()
+	_, err := NewReplicatedBackend(3, replicas[0], replicas[1])
+	if err == nil {
+		t.Errorf("NewReplicatedBackend: should have failed with a quorum larger than the number of replicas")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 3)
+	defer cleanup()
+	backend, err := NewReplicatedBackend(3, replicas[0], replicas[1], replicas[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []byte("test")
+	err = backend.WriteFile("test/key", contents)
+	if err != nil {
+		t.Errorf("WriteFile: should have succeeded (%s)", err)
+	}
+	for i, replica := range replicas {
+		data, err := replica.ReadFile("test/key")
+		if err != nil || !bytes.Equal(data, contents) {
+			t.Errorf("WriteFile: replica %d should have the file (%v)", i, err)
+		}
+	}
+}
+
+func TestWriteFile_Quorum(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 3)
+	defer cleanup()
+	backend, err := NewReplicatedBackend(2, replicas[0], replicas[1], replicas[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas[2].down = true
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: should have succeeded with quorum reached (%s)", err)
+	}
+	failures := backend.Failures()
+	if len(failures) != 1 || failures[0].Replica != 2 || failures[0].Path != "test/key" {
+		t.Errorf("WriteFile: expected a failure recorded for replica 2, got %+v", failures)
+	}
+
+	replicas[1].down = true
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err == nil {
+		t.Errorf("WriteFile: should have failed without quorum")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 2)
+	defer cleanup()
+	backend, err := NewReplicatedBackend(1, replicas[0], replicas[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas[1].down = true
+	contents := []byte("test")
+	err = backend.WriteFile("test/key", contents)
+	if err != nil {
+		t.Fatalf("Retry: failed to prepare (%s)", err)
+	}
+	err = backend.Retry()
+	if err == nil {
+		t.Errorf("Retry: should have failed while the replica is down")
+	}
+
+	replicas[1].down = false
+	err = backend.Retry()
+	if err != nil {
+		t.Errorf("Retry: should have succeeded (%s)", err)
+	}
+	if failures := backend.Failures(); len(failures) != 0 {
+		t.Errorf("Retry: expected no failures left, got %+v", failures)
+	}
+	data, err := replicas[1].ReadFile("test/key")
+	if err != nil || !bytes.Equal(data, contents) {
+		t.Errorf("Retry: replica should have caught up (%v)", err)
+	}
+}
+
+func TestReadFile_Fallback(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 2)
+	defer cleanup()
+	backend, err := NewReplicatedBackend(2, replicas[0], replicas[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []byte("test")
+	err = backend.WriteFile("test/key", contents)
+	if err != nil {
+		t.Fatalf("ReadFile: failed to prepare (%s)", err)
+	}
+	replicas[0].down = true
+	data, err := backend.ReadFile("test/key")
+	if err != nil {
+		t.Errorf("ReadFile: should have fallen back to the secondary (%s)", err)
+	}
+	if !bytes.Equal(data, contents) {
+		t.Errorf("ReadFile: stored value is different from original (expected '%s' got '%s')", contents, data)
+	}
+}
+
+func TestReadFile_SkipsFailedReplicas(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 2)
+	defer cleanup()
+	backend, err := NewReplicatedBackend(1, replicas[0], replicas[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.WriteFile("test/key", []byte("v1"))
+	if err != nil {
+		t.Fatalf("ReadFile: failed to prepare (%s)", err)
+	}
+	replicas[0].down = true
+	err = backend.WriteFile("test/key", []byte("v2"))
+	if err != nil {
+		t.Fatalf("ReadFile: failed to prepare (%s)", err)
+	}
+	err = backend.WriteFile("test/other", []byte("v2"))
+	if err != nil {
+		t.Fatalf("ReadFile: failed to prepare (%s)", err)
+	}
+	replicas[0].down = false
+
+	data, err := backend.ReadFile("test/key")
+	if err != nil || string(data) != "v2" {
+		t.Errorf("ReadFile: expected the value of the replica in sync, got '%s' (%v)", data, err)
+	}
+	found, err := backend.Exist("test/other")
+	if err != nil || !found {
+		t.Errorf("Exist: expected the replica in sync to be used (%v)", err)
+	}
+	files, err := backend.ListDir("test")
+	if err != nil || len(files) != 2 {
+		t.Errorf("ListDir: expected the replica in sync to be used, got %v (%v)", files, err)
+	}
+
+	replicas[1].down = true
+	_, err = backend.ReadFile("test/key")
+	if err == nil {
+		t.Errorf("ReadFile: should have failed without a replica in sync")
+	}
+}
+
+func TestDeleteFile_MissingOnReplica(t *testing.T) {
+	replicas, cleanup := newTestReplicas(t, 2)
+	defer cleanup()
+	backend, err := NewReplicatedBackend(1, replicas[0], replicas[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas[1].down = true
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("DeleteFile: failed to prepare (%s)", err)
+	}
+	replicas[1].down = false
+	// The secondary never got the file, deleting it there is already done
+	err = backend.DeleteFile("test/key")
+	if err != nil {
+		t.Errorf("DeleteFile: should have succeeded (%s)", err)
+	}
+	if failures := backend.Failures(); len(failures) != 0 {
+		t.Errorf("DeleteFile: expected no failures left, got %+v", failures)
+	}
+	err = backend.DeleteFile("test/key")
+	if err == nil {
+		t.Errorf("DeleteFile: deleting a file missing on every replica should have failed")
+	}
+
+	// A delete to retry for a file that is gone from the replica in the meantime is done
+	err = backend.WriteFile("test/other", []byte("test"))
+	if err != nil {
+		t.Fatalf("DeleteFile: failed to prepare (%s)", err)
+	}
+	replicas[1].down = true
+	err = backend.DeleteFile("test/other")
+	if err != nil {
+		t.Errorf("DeleteFile: should have succeeded with quorum reached (%s)", err)
+	}
+	if failures := backend.Failures(); len(failures) != 1 || failures[0].Op != OpDeleteFile {
+		t.Errorf("DeleteFile: expected a delete failure recorded for replica 1, got %+v", failures)
+	}
+	replicas[1].down = false
+	err = replicas[1].KvBackend.DeleteFile("test/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.Retry()
+	if err != nil {
+		t.Errorf("Retry: should have succeeded (%s)", err)
+	}
+	if failures := backend.Failures(); len(failures) != 0 {
+		t.Errorf("Retry: expected no failures left, got %+v", failures)
+	}
+}
@@ -0,0 +1,320 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+const (
+	defaultMaxAttempts  = 3
+	defaultInitialDelay = 100 * time.Millisecond
+	defaultMaxDelay     = 5 * time.Second
+	defaultMultiplier   = 2
+)
+
+// Policy configures how failed operations are retried. Zero values fall back to the defaults.
+type Policy struct {
+	// MaxAttempts is the number of attempts, including the first one. Defaults to 3.
+	MaxAttempts int
+	// InitialDelay is the delay before the first retry. Defaults to 100ms.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between attempts. Defaults to 5s.
+	MaxDelay time.Duration
+	// Multiplier is applied to the delay after every retry. Defaults to 2.
+	Multiplier float64
+	// Jitter randomly shortens each delay by up to this fraction (clamped between 0 and 1), so that
+	// clients failing at the same time don't retry at the same time
+	Jitter float64
+	// Context stops the retries once it is done, even in the middle of a delay, in which case its
+	// error is returned. Defaults to context.Background().
+	Context context.Context
+	// Retryable reports whether an error is worth retrying. Defaults to IsTemporary, backends usually
+	// provide a better one (e.g. gcs.IsTransient).
+	Retryable func(err error) bool
+}
+
+// IsTemporary reports whether err is a network error that timed out
+func IsTemporary(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryBackend retries the operations of the backend it wraps that fail with a retryable error. It
+// is a backends.Wrapper, forwarding the optional interfaces of the wrapped backend.
+//
+// Reads and deletes are always retried. A retried DeleteFile can report the file as missing if an
+// earlier attempt deleted it but failed to report it. Writes are only retried when they have a
+// precondition: WriteFileIf, and WriteFile when the backend is a backends.ConditionalWriter, whose
+// attempts are made conditional on the version of the file before the first one, so a retry never
+// overwrites a concurrent write. Other writes (CopyFile, MoveFile and streams) are attempted once,
+// since retrying them isn't safe.
+type RetryBackend struct {
+	backend backends.KvBackend
+	policy  Policy
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func NewRetryBackend(backend backends.KvBackend, policy Policy) *RetryBackend {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = defaultMaxAttempts
+	}
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = defaultInitialDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultMaxDelay
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = defaultMultiplier
+	}
+	if policy.Jitter < 0 {
+		policy.Jitter = 0
+	}
+	if policy.Jitter > 1 {
+		policy.Jitter = 1
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsTemporary
+	}
+	if policy.Context == nil {
+		policy.Context = context.Background()
+	}
+	return &RetryBackend{
+		backend: backend,
+		policy:  policy,
+		sleep:   sleep,
+	}
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *RetryBackend) Unwrap() backends.KvBackend {
+	return c.backend
+}
+
+// WriteFile never fails with backends.ErrPreconditionFailed: the precondition only protects its
+// retries
+func (c *RetryBackend) WriteFile(path string, value []byte) error {
+	if !backends.CapabilitiesOf(c.backend).ConditionalWrite {
+		return c.backend.WriteFile(path, value)
+	}
+	version, err := c.Version(path)
+	if err != nil {
+		return err
+	}
+	attempts := 0
+	err = c.do(func() error {
+		attempts++
+		return c.writeFileIf(path, value, version, attempts > 1)
+	})
+	if err != backends.ErrPreconditionFailed {
+		return err
+	}
+	if attempts == 1 {
+		// Someone else wrote the file since its version was read, which a plain write just overwrites
+		return c.backend.WriteFile(path, value)
+	}
+	// A concurrent write landed after an attempt that failed, which may have been stored before it:
+	// the concurrent write is kept, as if it came last
+	return nil
+}
+
+// Version is retried like reads
+func (c *RetryBackend) Version(path string) (string, error) {
+	cw, ok := c.backend.(backends.ConditionalWriter)
+	if !ok {
+		return "", backends.ErrNotSupported
+	}
+	var version string
+	err := c.do(func() error {
+		var err error
+		version, err = cw.Version(path)
+		return err
+	})
+	return version, err
+}
+
+func (c *RetryBackend) WriteFileIf(path string, value []byte, version string) error {
+	if _, ok := c.backend.(backends.ConditionalWriter); !ok {
+		return backends.ErrNotSupported
+	}
+	attempts := 0
+	return c.do(func() error {
+		attempts++
+		return c.writeFileIf(path, value, version, attempts > 1)
+	})
+}
+
+// writeFileIf makes one attempt of a conditional write
+func (c *RetryBackend) writeFileIf(path string, value []byte, version string, retry bool) error {
+	err := c.backend.(backends.ConditionalWriter).WriteFileIf(path, value, version)
+	if err == backends.ErrPreconditionFailed && retry {
+		// An earlier attempt may have been stored even though it failed
+		data, readErr := c.backend.ReadFile(path)
+		if readErr == nil && bytes.Equal(data, value) {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *RetryBackend) ReadFile(path string) ([]byte, error) {
+	var data []byte
+	err := c.do(func() error {
+		var err error
+		data, err = c.backend.ReadFile(path)
+		return err
+	})
+	return data, err
+}
+
+func (c *RetryBackend) DeleteFile(path string) error {
+	return c.do(func() error {
+		return c.backend.DeleteFile(path)
+	})
+}
+
+func (c *RetryBackend) DeleteDir(path string) error {
+	return c.do(func() error {
+		return c.backend.DeleteDir(path)
+	})
+}
+
+func (c *RetryBackend) ListDir(path string) ([]string, error) {
+	var files []string
+	err := c.do(func() error {
+		var err error
+		files, err = c.backend.ListDir(path)
+		return err
+	})
+	return files, err
+}
+
+func (c *RetryBackend) Exist(path string) (bool, error) {
+	var found bool
+	err := c.do(func() error {
+		var err error
+		found, err = c.backend.Exist(path)
+		return err
+	})
+	return found, err
+}
+
+func (c *RetryBackend) ListDirPage(path string, pageSize int, cursor string) ([]string, string, error) {
+	pager, ok := c.backend.(backends.Pager)
+	if !ok {
+		return nil, "", backends.ErrNotSupported
+	}
+	var files []string
+	var next string
+	err := c.do(func() error {
+		var err error
+		files, next, err = pager.ListDirPage(path, pageSize, cursor)
+		return err
+	})
+	return files, next, err
+}
+
+func (c *RetryBackend) CopyFile(src string, dst string) error {
+	copier, ok := c.backend.(backends.Copier)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	return copier.CopyFile(src, dst)
+}
+
+func (c *RetryBackend) MoveFile(src string, dst string) error {
+	mover, ok := c.backend.(backends.Mover)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	return mover.MoveFile(src, dst)
+}
+
+// OpenReader retries opening the stream, but not reading it
+func (c *RetryBackend) OpenReader(path string) (io.ReadCloser, error) {
+	streamer, ok := c.backend.(backends.Streamer)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	var rc io.ReadCloser
+	err := c.do(func() error {
+		var err error
+		rc, err = streamer.OpenReader(path)
+		return err
+	})
+	return rc, err
+}
+
+func (c *RetryBackend) OpenWriter(path string) (io.WriteCloser, error) {
+	streamer, ok := c.backend.(backends.Streamer)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	return streamer.OpenWriter(path)
+}
+
+func (c *RetryBackend) DeleteFiles(paths []string) error {
+	deleter, ok := c.backend.(backends.BatchDeleter)
+	if !ok {
+		return backends.ErrNotSupported
+	}
+	return c.do(func() error {
+		return deleter.DeleteFiles(paths)
+	})
+}
+
+func (c *RetryBackend) Watch(ctx context.Context, path string) (<-chan backends.Event, error) {
+	watcher, ok := c.backend.(backends.Watcher)
+	if !ok {
+		return nil, backends.ErrNotSupported
+	}
+	var events <-chan backends.Event
+	err := c.do(func() error {
+		var err error
+		events, err = watcher.Watch(ctx, path)
+		return err
+	})
+	return events, err
+}
+
+// do calls op until it succeeds, fails with an error that isn't retryable or runs out of attempts
+func (c *RetryBackend) do(op func() error) error {
+	delay := c.policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= c.policy.MaxAttempts || !c.policy.Retryable(err) {
+			return err
+		}
+		err = c.sleep(c.policy.Context, c.jitter(delay))
+		if err != nil {
+			return err
+		}
+		delay = time.Duration(float64(delay) * c.policy.Multiplier)
+		if delay > c.policy.MaxDelay {
+			delay = c.policy.MaxDelay
+		}
+	}
+}
+
+func (c *RetryBackend) jitter(delay time.Duration) time.Duration {
+	if c.policy.Jitter == 0 {
+		return delay
+	}
+	return delay - time.Duration(rand.Float64()*c.policy.Jitter*float64(delay))
+}
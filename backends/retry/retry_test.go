@@ -0,0 +1,316 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+var errTransient = errors.New("transient error")
+
+func isTransient(err error) bool {
+	return err == errTransient
+}
+
+// flakyBackend fails the next `failures` operations with errTransient. With `lost` set, writes are
+// stored before failing, like a write whose response never made it back.
+type flakyBackend struct {
+	local.LocalBackend
+	failures int
+	lost     bool
+	calls    int
+	// onVersion is called after reading a version, to simulate concurrent writers
+	onVersion func()
+}
+
+func (c *flakyBackend) fail() bool {
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		return true
+	}
+	return false
+}
+
+func (c *flakyBackend) ReadFile(path string) ([]byte, error) {
+	if c.fail() {
+		return nil, errTransient
+	}
+	return c.LocalBackend.ReadFile(path)
+}
+
+func (c *flakyBackend) WriteFile(path string, value []byte) error {
+	if c.fail() {
+		return errTransient
+	}
+	return c.LocalBackend.WriteFile(path, value)
+}
+
+func (c *flakyBackend) ListDirPage(path string, pageSize int, cursor string) ([]string, string, error) {
+	if c.fail() {
+		return nil, "", errTransient
+	}
+	return c.LocalBackend.ListDirPage(path, pageSize, cursor)
+}
+
+func (c *flakyBackend) Version(path string) (string, error) {
+	version, err := c.LocalBackend.Version(path)
+	if c.onVersion != nil {
+		c.onVersion()
+	}
+	return version, err
+}
+
+func (c *flakyBackend) WriteFileIf(path string, value []byte, version string) error {
+	if c.lost && c.failures > 0 {
+		c.failures--
+		err := c.LocalBackend.WriteFileIf(path, value, version)
+		if err != nil {
+			return err
+		}
+		return errTransient
+	}
+	if c.fail() {
+		return errTransient
+	}
+	return c.LocalBackend.WriteFileIf(path, value, version)
+}
+
+// unconditionalBackend hides WriteFileIf
+type unconditionalBackend struct {
+	backends.KvBackend
+}
+
+func newTestBackend(t *testing.T) (*flakyBackend, func()) {
+	dir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := local.NewLocalBackend(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return &flakyBackend{LocalBackend: backend}, func() { os.RemoveAll(dir) }
+}
+
+func newRetryBackend(backend backends.KvBackend, policy Policy) (*RetryBackend, *[]time.Duration) {
+	var delays []time.Duration
+	retrying := NewRetryBackend(backend, policy)
+	retrying.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return retrying, &delays
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+		backend, cleanup := newTestBackend(t)
+		t.Cleanup(cleanup)
+		return NewRetryBackend(backend, Policy{Retryable: isTransient})
+	})
+}
+
+func TestReadFile_Retry(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	err := backend.LocalBackend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("ReadFile: failed to prepare (%s)", err)
+	}
+	retrying, delays := newRetryBackend(backend, Policy{MaxAttempts: 4, InitialDelay: time.Second, MaxDelay: 3 * time.Second, Retryable: isTransient})
+
+	backend.failures = 3
+	_, err = retrying.ReadFile("test/key")
+	if err != nil {
+		t.Errorf("ReadFile: should have succeeded after retrying (%s)", err)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if len(*delays) != len(expected) {
+		t.Fatalf("ReadFile: expected delays %v, got %v", expected, *delays)
+	}
+	for i := range expected {
+		if (*delays)[i] != expected[i] {
+			t.Errorf("ReadFile: expected delays %v, got %v", expected, *delays)
+			break
+		}
+	}
+
+	backend.failures = 4
+	_, err = retrying.ReadFile("test/key")
+	if err != errTransient {
+		t.Errorf("ReadFile: should have failed after 4 attempts, got %v", err)
+	}
+}
+
+func TestReadFile_NotRetryable(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(backend, Policy{Retryable: isTransient})
+	_, err := retrying.ReadFile("missing")
+	if err == nil {
+		t.Errorf("ReadFile: should have failed for a missing file")
+	}
+	if backend.calls != 1 {
+		t.Errorf("ReadFile: should not have retried, got %d attempts", backend.calls)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, delays := newRetryBackend(backend, Policy{MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: time.Second, Jitter: 0.5, Retryable: isTransient})
+	backend.failures = 9
+	_, err := retrying.ListDir("")
+	if err != nil {
+		t.Fatalf("ListDir: should have succeeded after retrying (%s)", err)
+	}
+	for _, d := range *delays {
+		if d < time.Second/2 || d > time.Second {
+			t.Errorf("ListDir: delay %s out of the jitter range", d)
+		}
+	}
+}
+
+func TestJitter_Clamped(t *testing.T) {
+	for jitter, expected := range map[float64]float64{-1: 0, 0.5: 0.5, 2: 1} {
+		retrying := NewRetryBackend(nil, Policy{Jitter: jitter})
+		if retrying.policy.Jitter != expected {
+			t.Errorf("NewRetryBackend: expected jitter %v for %v, got %v", expected, jitter, retrying.policy.Jitter)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	retrying := NewRetryBackend(backend, Policy{MaxAttempts: 2, InitialDelay: time.Hour, Retryable: isTransient, Context: ctx})
+	backend.failures = 1
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := retrying.ReadFile("test/key")
+	if err != context.Canceled {
+		t.Errorf("ReadFile: expected the delay to be canceled, got %v", err)
+	}
+}
+
+func TestWriteFile_LostResponse(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(backend, Policy{Retryable: isTransient})
+	backend.failures = 1
+	backend.lost = true
+	err := retrying.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: should have detected the earlier attempt was stored (%s)", err)
+	}
+	data, err := backend.LocalBackend.ReadFile("test/key")
+	if err != nil || !bytes.Equal(data, []byte("test")) {
+		t.Errorf("WriteFile: stored value is different from original (got '%s', %v)", data, err)
+	}
+}
+
+func TestWriteFile_ConcurrentWrite(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(backend, Policy{Retryable: isTransient})
+	backend.failures = 1
+	backend.lost = true
+	// Simulates someone else writing between the lost attempt and the retry
+	retrying.sleep = func(context.Context, time.Duration) error {
+		err := backend.LocalBackend.WriteFile("test/key", []byte("other"))
+		if err != nil {
+			t.Fatalf("WriteFile: failed to prepare (%s)", err)
+		}
+		return nil
+	}
+	err := retrying.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: should not have failed on the concurrent write (%s)", err)
+	}
+	data, _ := backend.LocalBackend.ReadFile("test/key")
+	if !bytes.Equal(data, []byte("other")) {
+		t.Errorf("WriteFile: concurrent write should have been kept, got '%s'", data)
+	}
+}
+
+func TestWriteFile_Unconditional(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(unconditionalBackend{backend}, Policy{Retryable: isTransient})
+	backend.failures = 1
+	err := retrying.WriteFile("test/key", []byte("test"))
+	if err != errTransient {
+		t.Errorf("WriteFile: should not have retried an unconditional write, got %v", err)
+	}
+}
+
+func TestWriteFile_RacingWriter(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(backend, Policy{Retryable: isTransient})
+	// Someone else writes between the version being read and the first attempt
+	backend.onVersion = func() {
+		backend.onVersion = nil
+		err := backend.LocalBackend.WriteFile("test/key", []byte("other"))
+		if err != nil {
+			t.Fatalf("WriteFile: failed to prepare (%s)", err)
+		}
+	}
+	err := retrying.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: a plain write should not fail because of a concurrent write (%s)", err)
+	}
+	data, _ := backend.LocalBackend.ReadFile("test/key")
+	if !bytes.Equal(data, []byte("test")) {
+		t.Errorf("WriteFile: expected the write to overwrite the concurrent one, got '%s'", data)
+	}
+}
+
+func TestWriteFileIf_Retry(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(backend, Policy{Retryable: isTransient})
+	backend.failures = 1
+	backend.lost = true
+	err := retrying.WriteFileIf("test/key", []byte("test"), "")
+	if err != nil {
+		t.Errorf("WriteFileIf: should have detected the earlier attempt was stored (%s)", err)
+	}
+	err = retrying.WriteFileIf("test/key", []byte("other"), "")
+	if err != backends.ErrPreconditionFailed {
+		t.Errorf("WriteFileIf: expected a precondition error, got %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	backend, cleanup := newTestBackend(t)
+	defer cleanup()
+	retrying, _ := newRetryBackend(backend, Policy{Retryable: isTransient})
+	if caps := backends.CapabilitiesOf(retrying); caps != backends.CapabilitiesOf(backend.LocalBackend) {
+		t.Errorf("Capabilities: expected the capabilities of the wrapped backend, got %+v", caps)
+	}
+	retrying, _ = newRetryBackend(unconditionalBackend{backend}, Policy{Retryable: isTransient})
+	if backends.CapabilitiesOf(retrying).ConditionalWrite {
+		t.Errorf("Capabilities: should not report conditional writes the wrapped backend doesn't support")
+	}
+	err := retrying.WriteFileIf("test/key", []byte("test"), "")
+	if err != backends.ErrNotSupported {
+		t.Errorf("WriteFileIf: expected ErrNotSupported, got %v", err)
+	}
+	backend.failures = 1
+	retrying, _ = newRetryBackend(backend, Policy{Retryable: isTransient})
+	_, _, err = retrying.ListDirPage("", 10, "")
+	if err != nil {
+		t.Errorf("ListDirPage: should have been retried (%s)", err)
+	}
+}
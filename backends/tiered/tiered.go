@@ -0,0 +1,428 @@
+package tiered
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+type Mode int
+
+const (
+	// WriteThrough writes to the remote tier first and only then to the local tier
+	WriteThrough Mode = iota
+	// WriteBack writes to the local tier only and queues the change until Flush is called
+	WriteBack
+)
+
+// journalFile lives in the local tier and holds the changes that haven't reached the remote tier
+// yet, so they survive restarts
+const journalFile = ".multikv-tiered-journal"
+
+const (
+	opWrite      = "write"
+	opDeleteFile = "deleteFile"
+	opDeleteDir  = "deleteDir"
+)
+
+type pendingOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// id tells apart the changes queued for the same path, it isn't stored in the journal
+	id uint64
+}
+
+// TieredBackend uses a fast local backend as a cache in front of a remote backend. Reads are served
+// from the local tier whenever possible, so they keep working while the remote tier is unreachable.
+type TieredBackend struct {
+	local   backends.KvBackend
+	remote  backends.KvBackend
+	mode    Mode
+	mu      sync.Mutex
+	pending []pendingOp
+	nextID  uint64
+	// flushMu makes flushes run one at a time, without holding mu during their remote calls
+	flushMu sync.Mutex
+}
+
+func NewTieredBackend(local backends.KvBackend, remote backends.KvBackend, mode Mode) (*TieredBackend, error) {
+	backend := &TieredBackend{
+		local:  local,
+		remote: remote,
+		mode:   mode,
+	}
+	found, err := local.Exist(journalFile)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		journal, err := local.ReadFile(journalFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal (%s)", err)
+		}
+		err = json.Unmarshal(journal, &backend.pending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse journal (%s)", err)
+		}
+		for i := range backend.pending {
+			backend.nextID++
+			backend.pending[i].id = backend.nextID
+		}
+	}
+	return backend, nil
+}
+
+func (c *TieredBackend) WriteFile(path string, value []byte) error {
+	if c.mode == WriteThrough {
+		err := c.remote.WriteFile(path, value)
+		if err != nil {
+			return err
+		}
+		return c.local.WriteFile(path, value)
+	}
+	err := c.local.WriteFile(path, value)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(pendingOp{Op: opWrite, Path: path})
+}
+
+func (c *TieredBackend) ReadFile(path string) ([]byte, error) {
+	data, localErr := c.local.ReadFile(path)
+	if localErr == nil {
+		return data, nil
+	}
+	c.mu.Lock()
+	deleted := c.deleted(path)
+	c.mu.Unlock()
+	if deleted {
+		return nil, localErr
+	}
+	data, err := c.remote.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Filling the local tier is best effort, the remote copy is the source of truth. The file may have
+	// been deleted while it was read, and must not come back.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deleted(path) {
+		return nil, localErr
+	}
+	_ = c.local.WriteFile(path, data)
+	return data, nil
+}
+
+func (c *TieredBackend) DeleteFile(path string) error {
+	if c.mode == WriteThrough {
+		err := c.remote.DeleteFile(path)
+		if err != nil {
+			return err
+		}
+		return ignoreMissing(c.local, path, c.local.DeleteFile(path))
+	}
+	err := c.local.DeleteFile(path)
+	if err != nil {
+		// Files that aren't cached locally still have to be deleted from the remote tier, but a
+		// file that doesn't exist in either tier can't be deleted
+		found, existErr := c.remote.Exist(path)
+		if existErr != nil || !found {
+			return err
+		}
+	}
+	return c.enqueue(pendingOp{Op: opDeleteFile, Path: path})
+}
+
+func (c *TieredBackend) DeleteDir(path string) error {
+	if c.mode == WriteThrough {
+		err := c.remote.DeleteDir(path)
+		if err != nil {
+			return err
+		}
+		return c.local.DeleteDir(path)
+	}
+	err := c.local.DeleteDir(path)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(pendingOp{Op: opDeleteDir, Path: path})
+}
+
+func (c *TieredBackend) ListDir(path string) ([]string, error) {
+	remoteFiles, err := c.remote.ListDir(path)
+	if err != nil {
+		// Remote tier unreachable, the local tier is the best we have
+		localFiles, localErr := c.local.ListDir(path)
+		if localErr != nil {
+			return nil, err
+		}
+		return withoutJournal(path, localFiles), nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[string]bool, len(remoteFiles))
+	var files []string
+	for _, f := range remoteFiles {
+		// Deleted locally, the deletion just hasn't reached the remote tier yet
+		if c.deleted(filepath.Join(path, strings.TrimSuffix(f, "/"))) {
+			continue
+		}
+		seen[f] = true
+		files = append(files, f)
+	}
+	for _, f := range c.pendingWrites(path) {
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (c *TieredBackend) Exist(path string) (bool, error) {
+	found, err := c.local.Exist(path)
+	if err == nil && found {
+		return true, nil
+	}
+	c.mu.Lock()
+	deleted := c.deleted(path)
+	c.mu.Unlock()
+	if deleted {
+		return false, nil
+	}
+	return c.remote.Exist(path)
+}
+
+// Pending returns the paths changed locally that haven't been flushed to the remote tier yet
+func (c *TieredBackend) Pending() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var paths []string
+	for _, op := range c.pending {
+		paths = append(paths, op.Path)
+	}
+	return paths
+}
+
+// Flush pushes the queued changes to the remote tier, in the order they were made. Changes that
+// fail stay queued and are retried by the next Flush, like the changes queued while it runs.
+func (c *TieredBackend) Flush() error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	// The remote calls are made without holding mu, so that reads and writes go on meanwhile
+	c.mu.Lock()
+	journal := append([]pendingOp(nil), c.pending...)
+	c.mu.Unlock()
+	for _, op := range journal {
+		err := c.flushOp(op)
+		c.mu.Lock()
+		i := c.queued(op)
+		if i < 0 {
+			// Superseded by a later change while it was flushed, which is still queued
+			c.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("failed to flush %s of %s (%s)", op.Op, op.Path, err)
+		}
+		c.pending = append(c.pending[:i], c.pending[i+1:]...)
+		err = c.saveJournal()
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushOp pushes a queued change to the remote tier
+func (c *TieredBackend) flushOp(op pendingOp) error {
+	switch op.Op {
+	case opWrite:
+		data, err := c.local.ReadFile(op.Path)
+		if err != nil {
+			return err
+		}
+		return c.remote.WriteFile(op.Path, data)
+	case opDeleteFile:
+		return ignoreMissing(c.remote, op.Path, c.remote.DeleteFile(op.Path))
+	case opDeleteDir:
+		return c.remote.DeleteDir(op.Path)
+	}
+	return nil
+}
+
+// queued returns the position of op in the queue, or -1 if it isn't queued anymore. Must be called
+// with c.mu held.
+func (c *TieredBackend) queued(op pendingOp) int {
+	for i, p := range c.pending {
+		if p.id == op.id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reconcile brings the local tier back in sync with the remote tier, typically after a restart:
+// queued changes are flushed first, then every locally cached file is refreshed from (or dropped
+// if it no longer exists in) the remote tier. If the remote tier is unreachable the local tier is
+// left untouched and keeps serving reads.
+func (c *TieredBackend) Reconcile() error {
+	err := c.Flush()
+	if err != nil {
+		return err
+	}
+	return c.reconcileDir("")
+}
+
+func (c *TieredBackend) reconcileDir(path string) error {
+	files, err := c.local.ListDir(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range withoutJournal(path, files) {
+		child := filepath.Join(path, f)
+		if strings.HasSuffix(f, "/") {
+			err = c.reconcileDir(child)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		found, err := c.remote.Exist(child)
+		if err != nil {
+			return err
+		}
+		if !found {
+			err = c.local.DeleteFile(child)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := c.remote.ReadFile(child)
+		if err != nil {
+			return err
+		}
+		err = c.local.WriteFile(child, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueue records a local change and persists the journal
+func (c *TieredBackend) enqueue(op pendingOp) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Only the last change to a path matters, and deleting a directory supersedes anything queued below it
+	var pending []pendingOp
+	for _, p := range c.pending {
+		if p.Path == op.Path || (op.Op == opDeleteDir && isChildPath(op.Path, p.Path)) {
+			continue
+		}
+		pending = append(pending, p)
+	}
+	c.nextID++
+	op.id = c.nextID
+	c.pending = append(pending, op)
+	return c.saveJournal()
+}
+
+// saveJournal must be called with c.mu held
+func (c *TieredBackend) saveJournal() error {
+	if len(c.pending) == 0 {
+		return ignoreMissing(c.local, journalFile, c.local.DeleteFile(journalFile))
+	}
+	journal, err := json.Marshal(c.pending)
+	if err != nil {
+		return fmt.Errorf("failed to generate journal (%s)", err)
+	}
+	err = c.local.WriteFile(journalFile, journal)
+	if err != nil {
+		return fmt.Errorf("failed to write journal (%s)", err)
+	}
+	return nil
+}
+
+// deleted reports whether path was deleted locally, by itself or with a directory, and the deletion
+// hasn't been flushed yet: the remote tier may still have it, but it must be treated as gone. Must be
+// called with c.mu held.
+func (c *TieredBackend) deleted(path string) bool {
+	path = filepath.Clean(path)
+	deleted := false
+	for _, op := range c.pending {
+		switch {
+		case op.Op == opWrite && filepath.Clean(op.Path) == path:
+			deleted = false
+		case op.Op == opDeleteFile && filepath.Clean(op.Path) == path:
+			deleted = true
+		case op.Op == opDeleteDir && (filepath.Clean(op.Path) == path || isChildPath(op.Path, path)):
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// pendingWrites returns the entries of path that only exist locally so far. Must be called with c.mu held.
+func (c *TieredBackend) pendingWrites(path string) []string {
+	var names []string
+	for _, op := range c.pending {
+		if op.Op != opWrite || !isChildPath(path, op.Path) {
+			continue
+		}
+		rel, err := filepath.Rel(filepath.Clean(path), op.Path)
+		if err != nil {
+			continue
+		}
+		segments := strings.SplitN(rel, string(filepath.Separator), 2)
+		if len(segments) > 1 {
+			// Directory
+			names = append(names, segments[0]+"/")
+		} else {
+			names = append(names, segments[0])
+		}
+	}
+	return names
+}
+
+func withoutJournal(path string, files []string) []string {
+	if filepath.Clean(path) != "." {
+		return files
+	}
+	var filtered []string
+	for _, f := range files {
+		if f != journalFile {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+// ignoreMissing discards err if path doesn't exist (anymore) in backend
+func ignoreMissing(backend backends.KvBackend, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	found, existErr := backend.Exist(path)
+	if existErr == nil && !found {
+		return nil
+	}
+	return err
+}
+
+func isChildPath(parent string, path string) bool {
+	parent = filepath.Clean(parent)
+	if parent == "." {
+		return true
+	}
+	return strings.HasPrefix(filepath.Clean(path), parent+string(filepath.Separator))
+}
@@ -0,0 +1,381 @@
+package tiered
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/backendtest"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+var errUnreachable = errors.New("remote unreachable")
+
+// flakyBackend simulates a remote tier that can go offline
+type flakyBackend struct {
+	backends.KvBackend
+	down bool
+}
+
+func (c *flakyBackend) WriteFile(path string, value []byte) error {
+	if c.down {
+		return errUnreachable
+	}
+	return c.KvBackend.WriteFile(path, value)
+}
+
+func (c *flakyBackend) ReadFile(path string) ([]byte, error) {
+	if c.down {
+		return nil, errUnreachable
+	}
+	return c.KvBackend.ReadFile(path)
+}
+
+func (c *flakyBackend) ListDir(path string) ([]string, error) {
+	if c.down {
+		return nil, errUnreachable
+	}
+	return c.KvBackend.ListDir(path)
+}
+
+func (c *flakyBackend) Exist(path string) (bool, error) {
+	if c.down {
+		return false, errUnreachable
+	}
+	return c.KvBackend.Exist(path)
+}
+
+func newTestTiers(t *testing.T) (backends.KvBackend, *flakyBackend, func()) {
+	localDir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteDir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		os.RemoveAll(localDir)
+		os.RemoveAll(remoteDir)
+	}
+	localTier, err := local.NewLocalBackend(localDir)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	remoteTier, err := local.NewLocalBackend(remoteDir)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return localTier, &flakyBackend{KvBackend: remoteTier}, cleanup
+}
+
+func TestConformance(t *testing.T) {
+	modes := map[string]Mode{"WriteThrough": WriteThrough, "WriteBack": WriteBack}
+	for name, mode := range modes {
+		mode := mode
+		t.Run(name, func(t *testing.T) {
+			backendtest.RunConformance(t, func(t *testing.T) backends.KvBackend {
+				localTier, remoteTier, cleanup := newTestTiers(t)
+				t.Cleanup(cleanup)
+				backend, err := NewTieredBackend(localTier, remoteTier, mode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return backend
+			})
+		})
+	}
+}
+
+func TestReadFile_FillsLocalTier(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	contents := []byte("test")
+	err := remoteTier.WriteFile("test/key", contents)
+	if err != nil {
+		t.Fatalf("ReadFile: failed to prepare (%s)", err)
+	}
+	backend, err := NewTieredBackend(localTier, remoteTier, WriteThrough)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.ReadFile("test/key")
+	if err != nil {
+		t.Errorf("ReadFile: should not have failed (%s)", err)
+	}
+
+	// Once cached, the file is still served while the remote tier is down
+	remoteTier.down = true
+	data, err := backend.ReadFile("test/key")
+	if err != nil {
+		t.Errorf("ReadFile: should have been served from the local tier (%s)", err)
+	}
+	if !bytes.Equal(data, contents) {
+		t.Errorf("ReadFile: stored value is different from original (expected '%s' got '%s')", contents, data)
+	}
+}
+
+func TestWriteFile_WriteThrough(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	backend, err := NewTieredBackend(localTier, remoteTier, WriteThrough)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: should have succeeded (%s)", err)
+	}
+	for _, tier := range []backends.KvBackend{localTier, remoteTier} {
+		found, err := tier.Exist("test/key")
+		if err != nil || !found {
+			t.Errorf("WriteFile: file should have been written to both tiers")
+		}
+	}
+
+	remoteTier.down = true
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err == nil {
+		t.Errorf("WriteFile: should have failed with the remote tier down")
+	}
+}
+
+func TestWriteFile_WriteBack(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	backend, err := NewTieredBackend(localTier, remoteTier, WriteBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteTier.down = true
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Errorf("WriteFile: should have succeeded with the remote tier down (%s)", err)
+	}
+	err = backend.Flush()
+	if err == nil {
+		t.Errorf("Flush: should have failed with the remote tier down")
+	}
+
+	// Simulate a restart: the queued write is picked up from the journal
+	backend, err = NewTieredBackend(localTier, remoteTier, WriteBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := backend.Pending(); len(pending) != 1 || pending[0] != "test/key" {
+		t.Errorf("NewTieredBackend: expected pending write of test/key, got %v", pending)
+	}
+	remoteTier.down = false
+	err = backend.Flush()
+	if err != nil {
+		t.Errorf("Flush: should have succeeded (%s)", err)
+	}
+	found, err := remoteTier.Exist("test/key")
+	if err != nil || !found {
+		t.Errorf("Flush: file should have been written to the remote tier")
+	}
+	found, err = localTier.Exist(journalFile)
+	if err != nil || found {
+		t.Errorf("Flush: journal should have been removed once empty")
+	}
+}
+
+// blockingBackend blocks writes to the remote tier until release is closed
+type blockingBackend struct {
+	backends.KvBackend
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingBackend) WriteFile(path string, value []byte) error {
+	c.writing <- struct{}{}
+	<-c.release
+	return c.KvBackend.WriteFile(path, value)
+}
+
+func TestFlush_Concurrent(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	remote := &blockingBackend{KvBackend: remoteTier, writing: make(chan struct{}), release: make(chan struct{})}
+	backend, err := NewTieredBackend(localTier, remote, WriteBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.WriteFile("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("WriteFile: should have succeeded (%s)", err)
+	}
+	flushed := make(chan error)
+	go func() {
+		flushed <- backend.Flush()
+	}()
+	<-remote.writing
+
+	// Writes aren't blocked by the remote call of the flush, and are left for the next one
+	for _, path := range []string{"test/key", "test/other"} {
+		err = backend.WriteFile(path, []byte("updated"))
+		if err != nil {
+			t.Fatalf("WriteFile: should have succeeded during a flush (%s)", err)
+		}
+	}
+	close(remote.release)
+	err = <-flushed
+	if err != nil {
+		t.Errorf("Flush: should have succeeded (%s)", err)
+	}
+	if pending := backend.Pending(); len(pending) != 2 || pending[0] != "test/key" || pending[1] != "test/other" {
+		t.Errorf("Flush: expected the writes made during the flush to be pending, got %v", pending)
+	}
+	go func() {
+		for range remote.writing {
+		}
+	}()
+	err = backend.Flush()
+	if err != nil {
+		t.Errorf("Flush: should have succeeded (%s)", err)
+	}
+	close(remote.writing)
+	data, err := remoteTier.ReadFile("test/key")
+	if err != nil || string(data) != "updated" {
+		t.Errorf("Flush: expected the latest value in the remote tier, got '%s' (%v)", data, err)
+	}
+	if pending := backend.Pending(); len(pending) != 0 {
+		t.Errorf("Flush: expected nothing pending, got %v", pending)
+	}
+}
+
+func TestDelete_WriteBackAfterFlush(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	backend, err := NewTieredBackend(localTier, remoteTier, WriteBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"k/data", "other/data"} {
+		err = backend.WriteFile(path, []byte("v"))
+		if err != nil {
+			t.Fatalf("Delete: failed to prepare (%s)", err)
+		}
+	}
+	err = backend.Flush()
+	if err != nil {
+		t.Fatalf("Delete: failed to prepare (%s)", err)
+	}
+	err = backend.DeleteDir("k")
+	if err != nil {
+		t.Fatalf("Delete: DeleteDir should have succeeded (%s)", err)
+	}
+	err = backend.DeleteFile("other/data")
+	if err != nil {
+		t.Fatalf("Delete: DeleteFile should have succeeded (%s)", err)
+	}
+
+	// The remote tier still has both files until the next Flush
+	for i := 0; i < 2; i++ {
+		for _, path := range []string{"k/data", "other/data"} {
+			_, err = backend.ReadFile(path)
+			if err == nil {
+				t.Errorf("Delete: %s should not be readable after being deleted", path)
+			}
+			found, err := backend.Exist(path)
+			if err != nil || found {
+				t.Errorf("Delete: %s should not exist after being deleted (%v)", path, err)
+			}
+		}
+		files, err := backend.ListDir("")
+		if err != nil {
+			t.Errorf("Delete: ListDir should have succeeded (%s)", err)
+		}
+		for _, f := range files {
+			if f == "k/" {
+				t.Errorf("Delete: ListDir should not list the deleted directory, got %v", files)
+			}
+		}
+		err = backend.Flush()
+		if err != nil {
+			t.Fatalf("Delete: Flush should have succeeded (%s)", err)
+		}
+	}
+	found, err := localTier.Exist("k/data")
+	if err != nil || found {
+		t.Errorf("Delete: the local tier should not have been refilled (%v)", err)
+	}
+
+	// Writing again after the deletion brings the file back
+	err = backend.WriteFile("k/data", []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := backend.ReadFile("k/data")
+	if err != nil || string(data) != "v2" {
+		t.Errorf("Delete: expected v2, got %q (%v)", data, err)
+	}
+}
+
+func TestListDir_IncludesPendingWrites(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	err := remoteTier.WriteFile("test/remote", []byte("test"))
+	if err != nil {
+		t.Fatalf("ListDir: failed to prepare (%s)", err)
+	}
+	backend, err := NewTieredBackend(localTier, remoteTier, WriteBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.WriteFile("test/local", []byte("test"))
+	if err != nil {
+		t.Fatalf("ListDir: failed to prepare (%s)", err)
+	}
+	files, err := backend.ListDir("test")
+	if err != nil {
+		t.Errorf("ListDir: should not have failed (%s)", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("ListDir: should have returned both remote and pending files, got %v", files)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	localTier, remoteTier, cleanup := newTestTiers(t)
+	defer cleanup()
+	backend, err := NewTieredBackend(localTier, remoteTier, WriteThrough)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.WriteFile("test/updated", []byte("v1"))
+	if err != nil {
+		t.Fatalf("Reconcile: failed to prepare (%s)", err)
+	}
+	err = backend.WriteFile("test/deleted", []byte("v1"))
+	if err != nil {
+		t.Fatalf("Reconcile: failed to prepare (%s)", err)
+	}
+	// Changes made to the remote tier by someone else
+	err = remoteTier.WriteFile("test/updated", []byte("v2"))
+	if err != nil {
+		t.Fatalf("Reconcile: failed to prepare (%s)", err)
+	}
+	err = remoteTier.DeleteFile("test/deleted")
+	if err != nil {
+		t.Fatalf("Reconcile: failed to prepare (%s)", err)
+	}
+
+	err = backend.Reconcile()
+	if err != nil {
+		t.Errorf("Reconcile: should not have failed (%s)", err)
+	}
+	data, err := localTier.ReadFile("test/updated")
+	if err != nil || string(data) != "v2" {
+		t.Errorf("Reconcile: local tier should have been refreshed (got '%s', %v)", data, err)
+	}
+	found, err := localTier.Exist("test/deleted")
+	if err != nil || found {
+		t.Errorf("Reconcile: file removed from the remote tier should have been dropped locally")
+	}
+}
@@ -0,0 +1,120 @@
+package multikv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type BulkOptions struct {
+	// Concurrency is the number of keys read or written in parallel. Defaults to 1.
+	Concurrency int
+}
+
+type GetManyResult struct {
+	Values map[string][]byte
+	Failed map[string]error
+}
+
+type PutManyResult struct {
+	Written []string
+	Failed  map[string]error
+}
+
+// GetMany reads several keys in parallel. Keys that couldn't be read are reported in Failed,
+// including the ones not read yet when ctx is done.
+func (kv *KV) GetMany(ctx context.Context, paths []string, opts BulkOptions) (GetManyResult, error) {
+	result := GetManyResult{Values: make(map[string][]byte)}
+	var mu sync.Mutex
+	result.Failed = forEachKey(ctx, paths, opts.Concurrency, func(path string) error {
+		value, err := kv.GetContext(ctx, path)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		result.Values[path] = value
+		mu.Unlock()
+		return nil
+	})
+	return result, bulkError(ctx, "get", result.Failed)
+}
+
+// PutMany writes several keys in parallel. Keys that couldn't be written are reported in Failed,
+// including the ones not written yet when ctx is done.
+func (kv *KV) PutMany(ctx context.Context, values map[string][]byte, opts BulkOptions) (PutManyResult, error) {
+	result := PutManyResult{}
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var mu sync.Mutex
+	result.Failed = forEachKey(ctx, paths, opts.Concurrency, func(path string) error {
+		err := kv.PutContext(ctx, path, values[path])
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		result.Written = append(result.Written, path)
+		mu.Unlock()
+		return nil
+	})
+	sort.Strings(result.Written)
+	return result, bulkError(ctx, "put", result.Failed)
+}
+
+// forEachKey calls fn for every key with a pool of concurrency workers, and returns the errors per key.
+// Once ctx is done, the remaining keys fail with ctx.Err().
+func forEachKey(ctx context.Context, keys []string, concurrency int, fn func(key string) error) map[string]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				err := ctx.Err()
+				if err == nil {
+					err = fn(key)
+				}
+				if err != nil {
+					mu.Lock()
+					failed[key] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i, key := range keys {
+		select {
+		case work <- key:
+			continue
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		for _, key := range keys[i:] {
+			failed[key] = ctx.Err()
+		}
+		mu.Unlock()
+		break
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+func bulkError(ctx context.Context, op string, failed map[string]error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("failed to %s %d key(s)", op, len(failed))
+}
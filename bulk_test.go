@@ -0,0 +1,57 @@
+package multikv
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPutManyGetMany(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	values := make(map[string][]byte)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("test/key%02d", i)
+		values[path] = []byte(path)
+		paths = append(paths, path)
+	}
+
+	putResult, err := kv.PutMany(context.Background(), values, BulkOptions{Concurrency: 4})
+	if err != nil {
+		t.Fatalf("TestPutManyGetMany: PutMany should not have failed (%s)", err)
+	}
+	if !reflect.DeepEqual(putResult.Written, paths) {
+		t.Errorf("TestPutManyGetMany: written keys did not match. Expected: %v; Found: %v", paths, putResult.Written)
+	}
+
+	getResult, err := kv.GetMany(context.Background(), append(paths, "test/missing"), BulkOptions{Concurrency: 4})
+	if err == nil {
+		t.Errorf("TestPutManyGetMany: GetMany should have failed for the missing key")
+	}
+	if !reflect.DeepEqual(getResult.Values, values) {
+		t.Errorf("TestPutManyGetMany: read values did not match. Expected: %v; Found: %v", values, getResult.Values)
+	}
+	if len(getResult.Failed) != 1 || getResult.Failed["test/missing"] == nil {
+		t.Errorf("TestPutManyGetMany: expected only test/missing to fail, got %v", getResult.Failed)
+	}
+}
+
+func TestGetMany_Canceled(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestGetMany_Canceled: Put should have succeeded (%s)", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := kv.GetMany(ctx, []string{"test/key"}, BulkOptions{})
+	if err != context.Canceled {
+		t.Errorf("TestGetMany_Canceled: expected context.Canceled, got %v", err)
+	}
+	if result.Failed["test/key"] != context.Canceled || len(result.Values) != 0 {
+		t.Errorf("TestGetMany_Canceled: key should not have been read, got %+v", result)
+	}
+}
@@ -0,0 +1,243 @@
+package multikv
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+type CacheOptions struct {
+	// MaxEntries bounds the number of cached keys, including missing ones. Zero means unbounded.
+	MaxEntries int
+	// TTL is how long a value is served from memory before it is revalidated against its info file.
+	TTL time.Duration
+	// NegativeTTL is how long a missing key is remembered. Zero disables negative caching.
+	NegativeTTL time.Duration
+}
+
+type CacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	NegativeHits  uint64
+	Revalidations uint64
+	Evictions     uint64
+}
+
+// CachedKV is a read-through cache in front of a KV. Expired entries are not downloaded again
+// unless the generation recorded in the key's info file has changed since they were cached.
+type CachedKV struct {
+	kv      *KV
+	opts    CacheOptions
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	path      string
+	value     []byte
+	info      Info
+	missing   bool
+	expiresAt time.Time
+}
+
+func NewCachedKV(kv *KV, opts CacheOptions) *CachedKV {
+	return &CachedKV{
+		kv:      kv,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *CachedKV) Get(path string) ([]byte, error) {
+	entry, err := c.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return entry.value, nil
+}
+
+func (c *CachedKV) GetInfo(path string) (Info, error) {
+	entry, err := c.lookup(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return entry.info, nil
+}
+
+func (c *CachedKV) Put(path string, value []byte) error {
+	err := c.kv.Put(path, value)
+	c.Invalidate(path)
+	return err
+}
+
+func (c *CachedKV) Delete(path string) error {
+	err := c.kv.Delete(path)
+	c.InvalidatePrefix(path)
+	return err
+}
+
+func (c *CachedKV) List(path string) ([]Entry, error) {
+	return c.kv.List(path)
+}
+
+func (c *CachedKV) ListWithOptions(path string, opts ListOptions) ([]Entry, error) {
+	return c.kv.ListWithOptions(path, opts)
+}
+
+func (c *CachedKV) ListPage(prefix string, pageSize int, cursor string) ([]Entry, string, error) {
+	return c.kv.ListPage(prefix, pageSize, cursor)
+}
+
+func (c *CachedKV) Invalidate(path string) {
+	if key, err := c.kv.ParseKey(path); err == nil {
+		path = string(key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *CachedKV) InvalidatePrefix(prefix string) {
+	if key, err := c.kv.ParsePrefix(prefix); err == nil {
+		prefix = string(key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, el := range c.entries {
+		if path == prefix || isChildPath(prefix, path) {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *CachedKV) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *CachedKV) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *CachedKV) lookup(path string) (cacheEntry, error) {
+	// Entries are keyed by canonical key, so that all the spellings of a key share them
+	key, err := c.kv.ParseKey(path)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	path = string(key)
+	c.mu.Lock()
+	el, ok := c.entries[path]
+	var cached cacheEntry
+	if ok {
+		cached = *el.Value.(*cacheEntry)
+		if c.now().Before(cached.expiresAt) {
+			c.lru.MoveToFront(el)
+			if cached.missing {
+				c.stats.NegativeHits++
+				c.mu.Unlock()
+				return cacheEntry{}, ErrKeyNotFound
+			}
+			c.stats.Hits++
+			c.mu.Unlock()
+			return cached, nil
+		}
+	}
+	c.mu.Unlock()
+
+	// Expired entry: a single info read tells us whether the value we hold is still current
+	if ok && !cached.missing {
+		info, err := c.kv.GetInfo(path)
+		if err == nil && info.Generation == cached.info.Generation && info.UpdatedAt.Equal(cached.info.UpdatedAt) {
+			cached.expiresAt = c.now().Add(c.opts.TTL)
+			c.mu.Lock()
+			c.stats.Hits++
+			c.stats.Revalidations++
+			c.store(&cached)
+			c.mu.Unlock()
+			return cached, nil
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	entry, err := c.load(path)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	c.mu.Lock()
+	c.store(&entry)
+	c.mu.Unlock()
+	if entry.missing {
+		return cacheEntry{}, ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+func (c *CachedKV) load(path string) (cacheEntry, error) {
+	// The info file must be read before the data file: Put writes data first, so the data we read
+	// is at least as new as the generation we record
+	info, err := c.kv.GetInfo(path)
+	if err == nil {
+		var value []byte
+		value, err = c.kv.Get(path)
+		if err == nil {
+			return cacheEntry{path: path, value: value, info: info, expiresAt: c.now().Add(c.opts.TTL)}, nil
+		}
+	}
+	found, existErr := c.kv.Exist(path)
+	if existErr != nil {
+		return cacheEntry{}, existErr
+	}
+	if found {
+		return cacheEntry{}, err
+	}
+	if c.opts.NegativeTTL <= 0 {
+		return cacheEntry{}, ErrKeyNotFound
+	}
+	return cacheEntry{path: path, missing: true, expiresAt: c.now().Add(c.opts.NegativeTTL)}, nil
+}
+
+// store must be called with c.mu held
+func (c *CachedKV) store(entry *cacheEntry) {
+	if el, ok := c.entries[entry.path]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[entry.path] = c.lru.PushFront(entry)
+	for c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove must be called with c.mu held
+func (c *CachedKV) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).path)
+}
+
+// isChildPath tells if path is under parent, both being canonical keys
+func isChildPath(parent string, path string) bool {
+	if parent == "" {
+		return true
+	}
+	return strings.HasPrefix(path, parent+"/")
+}
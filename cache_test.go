@@ -0,0 +1,188 @@
+package multikv
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+func newTestCachedKV(t *testing.T, opts CacheOptions) (*CachedKV, func()) {
+	baseDir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := local.NewLocalBackend(baseDir)
+	if err != nil {
+		os.RemoveAll(baseDir)
+		t.Fatal(err)
+	}
+	return NewCachedKV(&KV{Backend: backend}, opts), func() { os.RemoveAll(baseDir) }
+}
+
+func TestCachedKV_Get(t *testing.T) {
+	cache, cleanup := newTestCachedKV(t, CacheOptions{MaxEntries: 10, TTL: time.Hour})
+	defer cleanup()
+	contents := []byte("test")
+	err := cache.Put("test-key", contents)
+	if err != nil {
+		t.Fatalf("TestCachedKV_Get: Put should have succeeded (%s)", err)
+	}
+	for i := 0; i < 3; i++ {
+		data, err := cache.Get("test-key")
+		if err != nil {
+			t.Errorf("TestCachedKV_Get: Get should not have failed (%s)", err)
+		}
+		if !bytes.Equal(data, contents) {
+			t.Errorf("TestCachedKV_Get: cached value is different from original (expected '%s' got '%s')", contents, data)
+		}
+	}
+	stats := cache.Stats()
+	if stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("TestCachedKV_Get: expected 1 miss and 2 hits, got %+v", stats)
+	}
+}
+
+// unreachableBackend fails every read with errRead and every existence check with errExist
+type unreachableBackend struct {
+	backends.KvBackend
+}
+
+var (
+	errRead  = errors.New("read failed")
+	errExist = errors.New("exist failed")
+)
+
+func (c unreachableBackend) ReadFile(path string) ([]byte, error) {
+	return nil, errRead
+}
+
+func (c unreachableBackend) Exist(path string) (bool, error) {
+	return false, errExist
+}
+
+func TestCachedKV_ExistError(t *testing.T) {
+	cache, cleanup := newTestCachedKV(t, CacheOptions{MaxEntries: 10, TTL: time.Hour, NegativeTTL: time.Hour})
+	defer cleanup()
+	cache.kv.Backend = unreachableBackend{cache.kv.Backend}
+	_, err := cache.Get("test-key")
+	if err != errExist {
+		t.Errorf("TestCachedKV_ExistError: expected the error of the existence check, got %v", err)
+	}
+}
+
+func TestCachedKV_Revalidate(t *testing.T) {
+	cache, cleanup := newTestCachedKV(t, CacheOptions{MaxEntries: 10, TTL: time.Minute})
+	defer cleanup()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	err := cache.Put("test-key", []byte("v1"))
+	if err != nil {
+		t.Fatalf("TestCachedKV_Revalidate: Put should have succeeded (%s)", err)
+	}
+	_, err = cache.Get("test-key")
+	if err != nil {
+		t.Fatalf("TestCachedKV_Revalidate: Get should not have failed (%s)", err)
+	}
+
+	// Unchanged key: the expired entry is revalidated without being reloaded
+	now = now.Add(2 * time.Minute)
+	_, err = cache.Get("test-key")
+	if err != nil {
+		t.Fatalf("TestCachedKV_Revalidate: Get should not have failed (%s)", err)
+	}
+	if stats := cache.Stats(); stats.Revalidations != 1 || stats.Misses != 1 {
+		t.Errorf("TestCachedKV_Revalidate: expected 1 revalidation and 1 miss, got %+v", stats)
+	}
+
+	// Key updated behind the cache's back: the new generation forces a reload
+	err = cache.kv.Put("test-key", []byte("v2"))
+	if err != nil {
+		t.Fatalf("TestCachedKV_Revalidate: Put should have succeeded (%s)", err)
+	}
+	now = now.Add(2 * time.Minute)
+	data, err := cache.Get("test-key")
+	if err != nil {
+		t.Fatalf("TestCachedKV_Revalidate: Get should not have failed (%s)", err)
+	}
+	if string(data) != "v2" {
+		t.Errorf("TestCachedKV_Revalidate: expected updated value 'v2', got '%s'", data)
+	}
+	if stats := cache.Stats(); stats.Misses != 2 {
+		t.Errorf("TestCachedKV_Revalidate: expected 2 misses, got %+v", stats)
+	}
+}
+
+func TestCachedKV_NegativeCaching(t *testing.T) {
+	cache, cleanup := newTestCachedKV(t, CacheOptions{TTL: time.Hour, NegativeTTL: time.Hour})
+	defer cleanup()
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get("missing-key")
+		if err != ErrKeyNotFound {
+			t.Errorf("TestCachedKV_NegativeCaching: expected ErrKeyNotFound, got %v", err)
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.NegativeHits != 1 {
+		t.Errorf("TestCachedKV_NegativeCaching: expected 1 miss and 1 negative hit, got %+v", stats)
+	}
+
+	// Writing through the cache clears the negative entry
+	err := cache.Put("missing-key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestCachedKV_NegativeCaching: Put should have succeeded (%s)", err)
+	}
+	_, err = cache.Get("missing-key")
+	if err != nil {
+		t.Errorf("TestCachedKV_NegativeCaching: Get should not have failed (%s)", err)
+	}
+}
+
+func TestCachedKV_Eviction(t *testing.T) {
+	cache, cleanup := newTestCachedKV(t, CacheOptions{MaxEntries: 2, TTL: time.Hour})
+	defer cleanup()
+	for _, path := range []string{"a", "b", "c"} {
+		err := cache.Put(path, []byte(path))
+		if err != nil {
+			t.Fatalf("TestCachedKV_Eviction: Put should have succeeded (%s)", err)
+		}
+		_, err = cache.Get(path)
+		if err != nil {
+			t.Fatalf("TestCachedKV_Eviction: Get should not have failed (%s)", err)
+		}
+	}
+	if stats := cache.Stats(); stats.Evictions != 1 {
+		t.Errorf("TestCachedKV_Eviction: expected 1 eviction, got %+v", stats)
+	}
+	if _, ok := cache.entries["a"]; ok {
+		t.Errorf("TestCachedKV_Eviction: least recently used key should have been evicted")
+	}
+}
+
+func TestCachedKV_KeyEncoding(t *testing.T) {
+	cache, cleanup := newTestCachedKV(t, CacheOptions{MaxEntries: 10, TTL: time.Hour})
+	defer cleanup()
+	cache.kv.KeyEncoding = PercentEncoding
+	path := "user@x.com/a b?"
+	err := cache.Put(path, []byte("v1"))
+	if err != nil {
+		t.Fatalf("TestCachedKV_KeyEncoding: Put should have succeeded (%s)", err)
+	}
+	data, err := cache.Get("/" + path)
+	if err != nil || string(data) != "v1" {
+		t.Errorf("TestCachedKV_KeyEncoding: expected v1, got '%s' (%v)", data, err)
+	}
+	err = cache.kv.Put(path, []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.InvalidatePrefix("user@x.com")
+	data, err = cache.Get(path)
+	if err != nil || string(data) != "v2" {
+		t.Errorf("TestCachedKV_KeyEncoding: expected v2 after invalidating its prefix, got '%s' (%v)", data, err)
+	}
+}
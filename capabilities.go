@@ -0,0 +1,216 @@
+package multikv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// Capabilities reports which optional features the backend supports natively. KV works with any
+// backend, but falls back to slower generic implementations for the missing ones.
+func (kv *KV) Capabilities() backends.Capabilities {
+	return backends.CapabilitiesOf(kv.Backend)
+}
+
+// GetReader returns the value of path as a stream. Backends that can't stream are read in one go.
+func (kv *KV) GetReader(path string) (_ io.ReadCloser, err error) {
+	backend, op := kv.begin(context.Background(), "GetReader", path)
+	size := 0
+	defer func() { op.end(size, err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.authorize(context.Background(), auth.Read, "GetReader", path)
+	if err != nil {
+		return nil, err
+	}
+	streamer, ok := backend.(backends.Streamer)
+	if !ok || !kv.Capabilities().Stream {
+		data, err := backend.ReadFile(kv.keyFile(path, "data"))
+		if err != nil {
+			return nil, err
+		}
+		value, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, err
+		}
+		size = len(value)
+		return ioutil.NopCloser(bytes.NewReader(value)), nil
+	}
+	rc, err := streamer.OpenReader(kv.keyFile(path, "data"))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{base64.NewDecoder(base64.StdEncoding, rc), rc}, nil
+}
+
+// PutReader is like Put, but reads the value from r. Backends that can't stream buffer it in memory.
+func (kv *KV) PutReader(path string, r io.Reader) (err error) {
+	backend, op := kv.begin(context.Background(), "PutReader", path)
+	var size int64
+	defer func() { op.end(int(size), err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(context.Background(), auth.Write, "PutReader", path)
+	if err != nil {
+		return err
+	}
+	streamer, ok := backend.(backends.Streamer)
+	if !ok || !kv.Capabilities().Stream {
+		value, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		size = int64(len(value))
+		return kv.put(context.Background(), backend, path, value)
+	}
+	oldChecksum, err := kv.auditChecksum(backend, path)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if kv.Audit != nil {
+		r = io.TeeReader(r, hash)
+	}
+	w, err := streamer.OpenWriter(kv.keyFile(path, "data"))
+	if err != nil {
+		return err
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	size, err = io.Copy(enc, r)
+	if err == nil {
+		// Flushes the last partial block
+		err = enc.Close()
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	err = updateInfo(backend, kv.storedPath(path), kv.NewInfo(path))
+	if err != nil || kv.Audit == nil {
+		return err
+	}
+	newChecksum := hex.EncodeToString(hash.Sum(nil))
+	return kv.audit(context.Background(), AuditRecord{Operation: "Put", Path: path, OldChecksum: oldChecksum, NewChecksum: newChecksum})
+}
+
+// Watch reports the keys written or deleted under prefix until ctx is done. The backend must
+// implement backends.Watcher, otherwise backends.ErrNotSupported is returned.
+func (kv *KV) Watch(ctx context.Context, prefix string) (_ <-chan backends.Event, err error) {
+	backend, op := kv.begin(ctx, "Watch", prefix)
+	defer func() { op.end(0, err) }()
+	prefix, err = kv.prefixPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.authorize(ctx, auth.List, "Watch", prefix)
+	if err != nil {
+		return nil, err
+	}
+	watcher, ok := backend.(backends.Watcher)
+	if !ok || !kv.Capabilities().Watch {
+		return nil, backends.ErrNotSupported
+	}
+	files, err := watcher.Watch(ctx, kv.storedPath(prefix))
+	if err != nil {
+		return nil, err
+	}
+	keys := make(chan backends.Event)
+	go func() {
+		defer close(keys)
+		for event := range files {
+			// The info file is the last one written by Put, and is always present for a key
+			stored := strings.TrimSuffix(event.Path, "/info")
+			if stored == event.Path {
+				continue
+			}
+			key, ok := kv.decodePath(stored)
+			if !ok {
+				continue
+			}
+			select {
+			case keys <- backends.Event{Type: event.Type, Path: key}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return keys, nil
+}
+
+// deleteKeyFiles removes the data and info files of keys, but not the keys nested under them, and
+// audits every key as a Delete
+func (kv *KV) deleteKeyFiles(backend backends.KvBackend, keys []string) error {
+	var paths []string
+	var records []AuditRecord
+	for _, key := range keys {
+		paths = append(paths, kv.keyFile(key, "info"), kv.keyFile(key, "data"))
+		oldChecksum, err := kv.auditChecksum(backend, key)
+		if err != nil {
+			return err
+		}
+		records = append(records, AuditRecord{Operation: "Delete", Path: key, OldChecksum: oldChecksum})
+	}
+	if deleter, ok := backend.(backends.BatchDeleter); ok && kv.Capabilities().BatchDelete {
+		err := deleter.DeleteFiles(paths)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, path := range paths {
+			err := backend.DeleteFile(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, record := range records {
+		err := kv.audit(context.Background(), record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a file within the store, server-side if the backend supports it
+func (kv *KV) copyFile(backend backends.KvBackend, src string, dst string) error {
+	if copier, ok := backend.(backends.Copier); ok && kv.Capabilities().Copy {
+		return copier.CopyFile(src, dst)
+	}
+	data, err := backend.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return backend.WriteFile(dst, data)
+}
+
+// moveFile moves a file within the store, server-side if the backend supports it
+func (kv *KV) moveFile(backend backends.KvBackend, src string, dst string) error {
+	if mover, ok := backend.(backends.Mover); ok && kv.Capabilities().Move {
+		return mover.MoveFile(src, dst)
+	}
+	err := kv.copyFile(backend, src, dst)
+	if err != nil {
+		return err
+	}
+	return backend.DeleteFile(src)
+}
@@ -0,0 +1,120 @@
+package multikv
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+// basicBackend hides the optional capabilities of the backend it wraps
+type basicBackend struct {
+	backends.KvBackend
+}
+
+func TestCapabilities(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	expected := backends.Capabilities{Copy: true, Move: true, ConditionalWrite: true, Stream: true, Watch: true, Page: true}
+	if c := kv.Capabilities(); c != expected {
+		t.Errorf("TestCapabilities: expected %+v for the local backend, got %+v", expected, c)
+	}
+	basic := KV{Backend: basicBackend{kv.Backend}}
+	if c := basic.Capabilities(); c != (backends.Capabilities{}) {
+		t.Errorf("TestCapabilities: expected no capabilities, got %+v", c)
+	}
+}
+
+func TestGetReaderPutReader(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	contents := strings.Repeat("streamed value ", 1000)
+	for _, store := range []KV{kv, {Backend: basicBackend{kv.Backend}}} {
+		err := store.PutReader("test-key", strings.NewReader(contents))
+		if err != nil {
+			t.Fatalf("TestGetReaderPutReader: PutReader should have succeeded (%s)", err)
+		}
+		// Streamed values are stored like any other
+		data, err := store.Get("test-key")
+		if err != nil || string(data) != contents {
+			t.Errorf("TestGetReaderPutReader: Get returned a different value (%v)", err)
+		}
+		rc, err := store.GetReader("test-key")
+		if err != nil {
+			t.Fatalf("TestGetReaderPutReader: GetReader should have succeeded (%s)", err)
+		}
+		data, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(data, []byte(contents)) {
+			t.Errorf("TestGetReaderPutReader: GetReader returned a different value (%v)", err)
+		}
+	}
+	info, err := kv.GetInfo("test-key")
+	if err != nil || info.Generation != 2 {
+		t.Errorf("TestGetReaderPutReader: expected generation 2, got %+v (%v)", info, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	backend := kv.Backend.(local.LocalBackend)
+	backend.WatchInterval = 10 * time.Millisecond
+	kv.Backend = backend
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := kv.Watch(ctx, "test")
+	if err != nil {
+		t.Fatalf("TestWatch: Watch should have succeeded (%s)", err)
+	}
+	err = kv.Put("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestWatch: Put should have succeeded (%s)", err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != backends.EventWrite || event.Path != "test/key" {
+			t.Errorf("TestWatch: expected a write event for test/key, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestWatch: no event received")
+	}
+
+	// Snapshots are not keys
+	_, err = kv.Snapshot("", "s1")
+	if err != nil {
+		t.Fatalf("TestWatch: Snapshot should have succeeded (%s)", err)
+	}
+	events, err = kv.Watch(ctx, "")
+	if err != nil {
+		t.Fatalf("TestWatch: Watch should have succeeded (%s)", err)
+	}
+	_, err = kv.Snapshot("", "s2")
+	if err != nil {
+		t.Fatalf("TestWatch: Snapshot should have succeeded (%s)", err)
+	}
+	err = kv.Put("other", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestWatch: Put should have succeeded (%s)", err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != backends.EventWrite || event.Path != "other" {
+			t.Errorf("TestWatch: expected a write event for other, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestWatch: no event received")
+	}
+
+	basic := KV{Backend: basicBackend{kv.Backend}}
+	_, err = basic.Watch(ctx, "test")
+	if err != backends.ErrNotSupported {
+		t.Errorf("TestWatch: expected ErrNotSupported, got %v", err)
+	}
+}
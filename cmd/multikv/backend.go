@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/gcs"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+// openBackend initializes a backend from a URL such as file:///tmp/multikv (or just /tmp/multikv)
+// and gs://my-gcs-bucket. The path of a gs:// URL, as in gs://my-gcs-bucket/tenants/acme, is used as
+// the root prefix of the backend in the bucket.
+func openBackend(ctx context.Context, rawURL string) (backends.KvBackend, error) {
+	if !strings.Contains(rawURL, "://") {
+		return local.NewLocalBackend(rawURL)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend URL %s (%s)", rawURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return local.NewLocalBackend(u.Path)
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid backend URL %s (missing bucket name)", rawURL)
+		}
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCS client (%s)", err)
+		}
+		return gcs.NewGCSBackend(client, u.Host, ctx, gcs.WithRootPrefix(u.Path)), nil
+	default:
+		return nil, fmt.Errorf("unsupported backend URL scheme %s", u.Scheme)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends/gcs"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+func TestOpenBackend_Local(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+	for _, rawURL := range []string{baseDir, "file://" + baseDir} {
+		backend, err := openBackend(context.Background(), rawURL)
+		if err != nil {
+			t.Errorf("openBackend: should have succeeded for %s (%s)", rawURL, err)
+			continue
+		}
+		if lb, ok := backend.(local.LocalBackend); !ok || lb.BasePath != baseDir {
+			t.Errorf("openBackend: expected a local backend at %s, got %#v", baseDir, backend)
+		}
+	}
+}
+
+func TestOpenBackend_GCS(t *testing.T) {
+	// Avoids looking up credentials, the backend isn't used
+	host, set := os.LookupEnv("STORAGE_EMULATOR_HOST")
+	os.Setenv("STORAGE_EMULATOR_HOST", "localhost:4443")
+	defer func() {
+		if set {
+			os.Setenv("STORAGE_EMULATOR_HOST", host)
+		} else {
+			os.Unsetenv("STORAGE_EMULATOR_HOST")
+		}
+	}()
+	for rawURL, expected := range map[string]string{"gs://bucket": "", "gs://bucket/": "", "gs://bucket/tenants/acme": "tenants/acme"} {
+		backend, err := openBackend(context.Background(), rawURL)
+		if err != nil {
+			t.Errorf("openBackend: should have succeeded for %s (%s)", rawURL, err)
+			continue
+		}
+		if gb, ok := backend.(gcs.GCSBackend); !ok || gb.RootPrefix() != expected {
+			t.Errorf("openBackend: expected a GCS backend with root prefix '%s' for %s, got %#v", expected, rawURL, backend)
+		}
+	}
+}
+
+func TestOpenBackend_Invalid(t *testing.T) {
+	for _, rawURL := range []string{"s3://bucket", "gs://"} {
+		_, err := openBackend(context.Background(), rawURL)
+		if err == nil {
+			t.Errorf("openBackend: should have failed for %s", rawURL)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/marcelocarlos/multikv"
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends/remote"
+	"github.com/marcelocarlos/multikv/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	store := flags.String("store", "", "store URL (e.g. /tmp/multikv or gs://my-gcs-bucket)")
+	addr := flags.String("addr", ":8080", "address to listen on")
+	grpcAddr := flags.String("grpc-addr", "", "address to serve the backend over gRPC on, disabled if empty")
+	tokens := flags.String("tokens", "", "JSON file mapping principals to their bearer token")
+	policy := flags.String("policy", "", "JSON file with the access policy, every operation is allowed if empty")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file, serves plain HTTP if empty")
+	tlsKey := flags.String("tls-key", "", "TLS key file")
+	clientCA := flags.String("client-ca", "", "CA file to verify client certificates with, authenticating clients by their common name")
+	_ = flags.Parse(args)
+	if *store == "" {
+		return fmt.Errorf("-store is required")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		return fmt.Errorf("-client-ca requires -tls-cert")
+	}
+
+	backend, err := openBackend(context.Background(), *store)
+	if err != nil {
+		return err
+	}
+	kv := &multikv.KV{Backend: backend}
+	if *policy != "" {
+		p, err := auth.LoadPolicy(*policy)
+		if err != nil {
+			return err
+		}
+		kv.Authorizer = &auth.Authorizer{Policy: p, Log: log.New(os.Stderr, "audit ", log.LstdFlags)}
+	}
+	var authenticators auth.Authenticators
+	if *tokens != "" {
+		t, err := auth.LoadTokens(*tokens)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, t)
+	}
+	if *clientCA != "" {
+		authenticators = append(authenticators, auth.ClientCertificates{})
+	}
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		tlsConfig, err = serverTLSConfig(*tlsCert, *tlsKey, *clientCA)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s (%s)", *grpcAddr, err)
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		if len(authenticators) > 0 {
+			opts = append(opts, grpc.UnaryInterceptor(auth.UnaryServerInterceptor(authenticators)),
+				grpc.StreamInterceptor(auth.StreamServerInterceptor(authenticators)))
+		}
+		s := grpc.NewServer(opts...)
+		remote.Register(s, backend, remote.WithAuthorizer(kv.Authorizer))
+		log.Printf("serving %s over gRPC on %s", *store, *grpcAddr)
+		go func() {
+			log.Fatal(s.Serve(listener))
+		}()
+	}
+
+	var opts []server.Option
+	if len(authenticators) > 0 {
+		opts = append(opts, server.WithAuthenticator(authenticators))
+	}
+	httpServer := &http.Server{Addr: *addr, Handler: server.NewServer(kv, opts...), TLSConfig: tlsConfig}
+	log.Printf("serving %s on %s", *store, *addr)
+	if tlsConfig != nil {
+		return httpServer.ListenAndServeTLS("", "")
+	}
+	return httpServer.ListenAndServe()
+}
+
+// serverTLSConfig loads the certificate of the server, and requires clients to present a certificate
+// signed by clientCA if set
+func serverTLSConfig(certFile string, keyFile string, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate (%s)", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA (%s)", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
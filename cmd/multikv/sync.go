@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/marcelocarlos/multikv"
+)
+
+func runSync(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	src := flags.String("src", "", "source store URL (e.g. /tmp/multikv or gs://my-gcs-bucket)")
+	dst := flags.String("dst", "", "destination store URL")
+	opts := multikv.SyncOptions{}
+	flags.StringVar(&opts.Prefix, "prefix", "", "only sync keys under this prefix")
+	flags.BoolVar(&opts.Incremental, "incremental", false, "skip keys that are already up to date in the destination")
+	flags.BoolVar(&opts.Checksum, "checksum", false, "compare values instead of update times in incremental mode")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "only print what would be done")
+	flags.BoolVar(&opts.Delete, "delete", false, "delete keys from the destination that don't exist in the source")
+	flags.IntVar(&opts.Concurrency, "concurrency", 4, "number of keys copied in parallel")
+	_ = flags.Parse(args)
+	if *src == "" || *dst == "" {
+		return fmt.Errorf("both -src and -dst are required")
+	}
+
+	ctx := context.Background()
+	srcBackend, err := openBackend(ctx, *src)
+	if err != nil {
+		return err
+	}
+	dstBackend, err := openBackend(ctx, *dst)
+	if err != nil {
+		return err
+	}
+	result, err := multikv.Sync(multikv.KV{Backend: srcBackend}, multikv.KV{Backend: dstBackend}, opts)
+	prefix := ""
+	if opts.DryRun {
+		prefix = "(dry-run) "
+	}
+	for _, key := range result.Copied {
+		fmt.Printf("%scopied %s\n", prefix, key)
+	}
+	for _, key := range result.Deleted {
+		fmt.Printf("%sdeleted %s\n", prefix, key)
+	}
+	var failed []string
+	for key := range result.Failed {
+		failed = append(failed, key)
+	}
+	sort.Strings(failed)
+	for _, key := range failed {
+		fmt.Printf("failed %s: %s\n", key, result.Failed[key])
+	}
+	fmt.Printf("%s%d copied, %d skipped, %d deleted, %d failed\n", prefix, len(result.Copied), len(result.Skipped), len(result.Deleted), len(result.Failed))
+	return err
+}
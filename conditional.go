@@ -0,0 +1,94 @@
+package multikv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+var ErrGenerationMismatch = errors.New("generation mismatch")
+
+// PutIf writes value only if the key is still at generation, 0 meaning that the key must not exist
+// yet, and returns the info of the new generation. It fails with ErrGenerationMismatch if the key
+// was written in the meantime, including by a concurrent PutIf. The backend must implement
+// backends.ConditionalWriter, otherwise backends.ErrNotSupported is returned.
+func (kv *KV) PutIf(path string, value []byte, generation int64) (Info, error) {
+	return kv.PutIfContext(context.Background(), path, value, generation)
+}
+
+func (kv *KV) PutIfContext(ctx context.Context, path string, value []byte, generation int64) (_ Info, err error) {
+	backend, op := kv.begin(ctx, "PutIf", path)
+	defer func() { op.end(len(value), err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return Info{}, err
+	}
+	err = kv.authorize(ctx, auth.Write, "PutIf", path)
+	if err != nil {
+		return Info{}, err
+	}
+	cw, ok := backend.(backends.ConditionalWriter)
+	if !ok || !kv.Capabilities().ConditionalWrite {
+		return Info{}, backends.ErrNotSupported
+	}
+	infoPath := kv.keyFile(path, "info")
+	dataPath := kv.keyFile(path, "data")
+	// Versions are taken before reading the info, so any change made after it fails the writes below
+	infoVersion, err := cw.Version(infoPath)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to read info file (%s)", err)
+	}
+	dataVersion, err := cw.Version(dataPath)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to read data file (%s)", err)
+	}
+	oldChecksum, err := kv.auditChecksum(backend, path)
+	if err != nil {
+		return Info{}, err
+	}
+	info := kv.NewInfo(path)
+	current := int64(0)
+	if infoVersion != "" {
+		info, err = getInfo(backend, kv.storedPath(path))
+		if err != nil {
+			return Info{}, fmt.Errorf("failed to read info file (%s)", err)
+		}
+		current = info.Generation
+		info.UpdatedAt = time.Now()
+		info.Generation++
+	}
+	if current != generation {
+		return Info{}, ErrGenerationMismatch
+	}
+
+	// Both writes are conditional: of two concurrent writers, the one that loses the data write
+	// doesn't write anything
+	err = cw.WriteFileIf(dataPath, []byte(base64.StdEncoding.EncodeToString(value)), dataVersion)
+	if err == backends.ErrPreconditionFailed {
+		return Info{}, ErrGenerationMismatch
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to write data file (%s)", err)
+	}
+	infoJSON, err := json.Marshal(&info)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to generate info file (%s)", err)
+	}
+	err = cw.WriteFileIf(infoPath, infoJSON, infoVersion)
+	if err == backends.ErrPreconditionFailed {
+		return Info{}, ErrGenerationMismatch
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to write info file (%s)", err)
+	}
+	if kv.Audit != nil {
+		err = kv.audit(ctx, AuditRecord{Operation: "Put", Path: path, OldChecksum: oldChecksum, NewChecksum: checksum(value)})
+	}
+	return info, err
+}
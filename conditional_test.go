@@ -0,0 +1,41 @@
+package multikv
+
+import (
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+func TestPutIf(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	info, err := kv.PutIf("test/key", []byte("v1"), 0)
+	if err != nil {
+		t.Fatalf("TestPutIf: creating the key should have succeeded (%s)", err)
+	}
+	if info.Generation != 1 {
+		t.Errorf("TestPutIf: expected generation 1, got %d", info.Generation)
+	}
+	_, err = kv.PutIf("test/key", []byte("v2"), 0)
+	if err != ErrGenerationMismatch {
+		t.Errorf("TestPutIf: creating an existing key should have failed, got %v", err)
+	}
+	info, err = kv.PutIf("test/key", []byte("v2"), 1)
+	if err != nil || info.Generation != 2 {
+		t.Fatalf("TestPutIf: updating generation 1 should have succeeded (%+v, %v)", info, err)
+	}
+	_, err = kv.PutIf("test/key", []byte("v3"), 1)
+	if err != ErrGenerationMismatch {
+		t.Errorf("TestPutIf: updating a stale generation should have failed, got %v", err)
+	}
+	data, err := kv.Get("test/key")
+	if err != nil || string(data) != "v2" {
+		t.Errorf("TestPutIf: expected 'v2', got '%s' (%v)", data, err)
+	}
+
+	basic := KV{Backend: basicBackend{kv.Backend}}
+	_, err = basic.PutIf("test/key", []byte("v3"), 2)
+	if err != backends.ErrNotSupported {
+		t.Errorf("TestPutIf: expected ErrNotSupported without a ConditionalWriter, got %v", err)
+	}
+}
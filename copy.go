@@ -0,0 +1,146 @@
+package multikv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// Copy copies the key src to dst or, if src is a prefix, every key under it to the same relative
+// path under dst. Values are copied server-side when the backend supports it, and the copies keep
+// the CreatedAt of the originals.
+func (kv *KV) Copy(src string, dst string) error {
+	return kv.CopyContext(context.Background(), src, dst)
+}
+
+func (kv *KV) CopyContext(ctx context.Context, src string, dst string) (err error) {
+	backend, op := kv.begin(ctx, "Copy", src)
+	defer func() { op.end(0, err) }()
+	src, dst, err = kv.transferPaths(src, dst)
+	if err != nil {
+		return err
+	}
+	err = kv.authorizeTransfer(ctx, "Copy", src, dst, auth.Read)
+	if err != nil {
+		return err
+	}
+	return kv.transfer(ctx, backend, "Copy", src, dst, kv.copyFile)
+}
+
+// Move is like Copy, but removes src afterwards
+func (kv *KV) Move(src string, dst string) error {
+	return kv.MoveContext(context.Background(), src, dst)
+}
+
+func (kv *KV) MoveContext(ctx context.Context, src string, dst string) (err error) {
+	backend, op := kv.begin(ctx, "Move", src)
+	defer func() { op.end(0, err) }()
+	src, dst, err = kv.transferPaths(src, dst)
+	if err != nil {
+		return err
+	}
+	err = kv.authorizeTransfer(ctx, "Move", src, dst, auth.Read, auth.Write)
+	if err != nil {
+		return err
+	}
+	err = kv.transfer(ctx, backend, "Move", src, dst, kv.moveFile)
+	if err != nil {
+		return err
+	}
+	return backend.DeleteDir(kv.storedPath(src))
+}
+
+// transferPaths validates the keys of a Copy or Move, which can't overlap
+func (kv *KV) transferPaths(src string, dst string) (string, string, error) {
+	src, err := kv.keyPath(src)
+	if err != nil {
+		return "", "", err
+	}
+	dst, err = kv.keyPath(dst)
+	if err != nil {
+		return "", "", err
+	}
+	if src == dst || isChildPath(src, dst) || isChildPath(dst, src) {
+		return "", "", fmt.Errorf("cannot copy %s to %s, paths overlap", src, dst)
+	}
+	return src, dst, nil
+}
+
+// authorizeTransfer checks srcPerms on src and the permission to write dst
+func (kv *KV) authorizeTransfer(ctx context.Context, op string, src string, dst string, srcPerms ...auth.Permission) error {
+	for _, perm := range srcPerms {
+		err := kv.authorize(ctx, perm, op, src)
+		if err != nil {
+			return err
+		}
+	}
+	return kv.authorize(ctx, auth.Write, op, dst)
+}
+
+func (kv *KV) transfer(ctx context.Context, backend backends.KvBackend, op string, src string, dst string, transferFile func(backend backends.KvBackend, src string, dst string) error) error {
+	var keys []string
+	err := kv.walk(backend, src, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys found at %s", src)
+	}
+	for _, key := range keys {
+		target := dst + strings.TrimPrefix(key, src)
+		info, err := kv.transferInfo(backend, key, target)
+		if err != nil {
+			return err
+		}
+		oldChecksum, err := kv.auditChecksum(backend, target)
+		if err != nil {
+			return err
+		}
+		newChecksum, err := kv.auditChecksum(backend, key)
+		if err != nil {
+			return err
+		}
+		// data first, like Put
+		err = transferFile(backend, kv.keyFile(key, "data"), kv.keyFile(target, "data"))
+		if err != nil {
+			return fmt.Errorf("failed to copy %s to %s (%s)", key, target, err)
+		}
+		infoJSON, err := json.Marshal(&info)
+		if err != nil {
+			return fmt.Errorf("failed to generate info file (%s)", err)
+		}
+		err = backend.WriteFile(kv.keyFile(target, "info"), infoJSON)
+		if err != nil {
+			return fmt.Errorf("failed to write info file (%s)", err)
+		}
+		err = kv.audit(ctx, AuditRecord{Operation: op, Path: key, Target: target, OldChecksum: oldChecksum, NewChecksum: newChecksum})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferInfo returns the info of key as it should be stored at target
+func (kv *KV) transferInfo(backend backends.KvBackend, key string, target string) (Info, error) {
+	info, err := getInfo(backend, kv.storedPath(key))
+	if err != nil {
+		return info, fmt.Errorf("failed to read info file of %s (%s)", key, err)
+	}
+	info.Path = target
+	// Overwriting an existing key must still be seen as a change by generation checks
+	existing, err := getInfo(backend, kv.storedPath(target))
+	if err == nil {
+		info.Generation = existing.Generation + 1
+		info.UpdatedAt = time.Now()
+	}
+	return info, nil
+}
@@ -0,0 +1,84 @@
+package multikv
+
+import (
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("src", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestCopy: Put should have succeeded (%s)", err)
+	}
+	srcInfo, _ := kv.GetInfo("src")
+
+	err = kv.Copy("src", "dst")
+	if err != nil {
+		t.Fatalf("TestCopy: Copy should not have failed (%s)", err)
+	}
+	for _, path := range []string{"src", "dst"} {
+		data, err := kv.Get(path)
+		if err != nil || string(data) != "test" {
+			t.Errorf("TestCopy: %s should have the original value (got '%s', %v)", path, data, err)
+		}
+	}
+	dstInfo, err := kv.GetInfo("dst")
+	if err != nil {
+		t.Fatalf("TestCopy: GetInfo should not have failed (%s)", err)
+	}
+	if dstInfo.Path != "dst" || !dstInfo.CreatedAt.Equal(srcInfo.CreatedAt) {
+		t.Errorf("TestCopy: copied info should keep CreatedAt and point to the new path, got %+v", dstInfo)
+	}
+
+	// Overwriting an existing key bumps its generation
+	err = kv.Copy("src", "dst")
+	if err != nil {
+		t.Fatalf("TestCopy: Copy should not have failed (%s)", err)
+	}
+	dstInfo, _ = kv.GetInfo("dst")
+	if dstInfo.Generation != 2 {
+		t.Errorf("TestCopy: expected generation 2 after overwriting, got %d", dstInfo.Generation)
+	}
+}
+
+func TestMove_Prefix(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	for _, path := range []string{"src/a", "src/sub/b"} {
+		err := kv.Put(path, []byte(path))
+		if err != nil {
+			t.Fatalf("TestMove_Prefix: Put should have succeeded (%s)", err)
+		}
+	}
+	err := kv.Move("src", "dst")
+	if err != nil {
+		t.Fatalf("TestMove_Prefix: Move should not have failed (%s)", err)
+	}
+	for _, path := range []string{"a", "sub/b"} {
+		data, err := kv.Get("dst/" + path)
+		if err != nil || string(data) != "src/"+path {
+			t.Errorf("TestMove_Prefix: dst/%s should have been moved (got '%s', %v)", path, data, err)
+		}
+	}
+	found, err := kv.Backend.Exist("src")
+	if err != nil || found {
+		t.Errorf("TestMove_Prefix: src should have been removed")
+	}
+}
+
+func TestCopy_Invalid(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("src", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestCopy_Invalid: Put should have succeeded (%s)", err)
+	}
+	tests := [][2]string{{"missing", "dst"}, {"src", "src/sub"}, {"src", "src"}, {"", "dst"}}
+	for _, test := range tests {
+		err = kv.Copy(test[0], test[1])
+		if err == nil {
+			t.Errorf("TestCopy_Invalid: copying %s to %s should have failed", test[0], test[1])
+		}
+	}
+}
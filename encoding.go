@@ -0,0 +1,160 @@
+package multikv
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KeyEncoding encodes each segment of a key into a name that can be stored by any backend, and
+// decodes it back. Encoded segments must not contain slashes, nor be ".", "..", "data", "info" or
+// ".snapshots", nor start with ".multikv-".
+type KeyEncoding interface {
+	EncodeSegment(segment string) string
+	DecodeSegment(name string) (string, error)
+}
+
+var (
+	// PercentEncoding keeps letters, digits and -_.~ as they are, and escapes every other byte as %XX,
+	// so that stored keys stay readable
+	PercentEncoding KeyEncoding = percentEncoding{}
+	// Base32Encoding stores segments in padded base32hex, which only uses digits, uppercase letters
+	// and "=", for backends that are case-insensitive or restrict names further
+	Base32Encoding KeyEncoding = base32Encoding{}
+)
+
+type percentEncoding struct{}
+
+func (percentEncoding) EncodeSegment(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		// The first byte of the names used by the store itself is escaped as well
+		if isUnreserved(c) && !(i == 0 && isReservedName(segment)) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (percentEncoding) DecodeSegment(name string) (string, error) {
+	return url.PathUnescape(name)
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0
+}
+
+func isReservedName(segment string) bool {
+	switch segment {
+	case ".", "..", "data", "info", snapshotsDir:
+		return true
+	}
+	return strings.HasPrefix(segment, internalPrefix)
+}
+
+// base32Encoding is padded, so that no name is 4 characters long like "data" and "info"
+type base32Encoding struct{}
+
+func (base32Encoding) EncodeSegment(segment string) string {
+	return base32.HexEncoding.EncodeToString([]byte(segment))
+}
+
+func (base32Encoding) DecodeSegment(name string) (string, error) {
+	segment, err := base32.HexEncoding.DecodeString(name)
+	return string(segment), err
+}
+
+// parseEncodedPrefix is ParsePrefix for a KV with a KeyEncoding: segments can hold any byte but
+// slashes, which still separate them, and the length limits apply to the encoded key
+func parseEncodedPrefix(encoding KeyEncoding, path string) (Key, error) {
+	var segments []string
+	length := 0
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		encoded := len(encoding.EncodeSegment(segment))
+		if encoded > MaxSegmentLength {
+			return "", fmt.Errorf("%w %q (encoded segment longer than %d bytes)", ErrInvalidKey, path, MaxSegmentLength)
+		}
+		if len(segments) > 0 {
+			length++
+		}
+		length += encoded
+		segments = append(segments, segment)
+	}
+	if length > MaxKeyLength {
+		return "", fmt.Errorf("%w %q (encoded key longer than %d bytes)", ErrInvalidKey, path, MaxKeyLength)
+	}
+	return Key(strings.Join(segments, "/")), nil
+}
+
+// storedPath returns the path of the directory of key in the backend
+func (kv *KV) storedPath(key string) string {
+	return joinKey(kv.root, kv.encodedKey(key))
+}
+
+// encodedKey returns key encoded with the KeyEncoding of kv, if any
+func (kv *KV) encodedKey(key string) string {
+	if kv.KeyEncoding == nil {
+		return key
+	}
+	return encodeKey(kv.KeyEncoding, key)
+}
+
+// encodeKey encodes every segment of key with encoding
+func encodeKey(encoding KeyEncoding, key string) string {
+	if key == "" {
+		return key
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = encoding.EncodeSegment(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// keyFile returns the path in the backend of the file name ("data" or "info") of key
+func (kv *KV) keyFile(key string, name string) string {
+	return joinKey(kv.storedPath(key), name)
+}
+
+// decodeName returns the segment a name listed in the backend stands for. Names that the KeyEncoding
+// of kv doesn't produce, e.g. the ones of other data stored in the backend, are not keys.
+func (kv *KV) decodeName(name string) (string, bool) {
+	if kv.KeyEncoding == nil {
+		return name, true
+	}
+	segment, err := kv.KeyEncoding.DecodeSegment(name)
+	if err != nil || segment == "" || kv.KeyEncoding.EncodeSegment(segment) != name {
+		return "", false
+	}
+	return segment, true
+}
+
+// decodePath is decodeName for a path in the backend, which must be under the root of kv. Paths
+// going through names used by the store itself (e.g. the snapshots) are not keys.
+func (kv *KV) decodePath(stored string) (string, bool) {
+	if kv.root != "" {
+		if !strings.HasPrefix(stored, kv.root+"/") {
+			return "", false
+		}
+		stored = strings.TrimPrefix(stored, kv.root+"/")
+	}
+	segments := strings.Split(stored, "/")
+	for i, name := range segments {
+		if isReservedName(name) {
+			return "", false
+		}
+		segment, ok := kv.decodeName(name)
+		if !ok {
+			return "", false
+		}
+		segments[i] = segment
+	}
+	return strings.Join(segments, "/"), true
+}
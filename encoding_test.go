@@ -0,0 +1,122 @@
+package multikv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	segments := []string{"alice@example.com", "https:", "a?b=c&d", "café", "100%", "a b", ".", "..", "data", "info", ".snapshots", ".multikv-tmp-x", "\x00\xff"}
+	for _, encoding := range []KeyEncoding{PercentEncoding, Base32Encoding} {
+		for _, segment := range segments {
+			name := encoding.EncodeSegment(segment)
+			if strings.Contains(name, "/") || isReservedName(name) {
+				t.Errorf("TestKeyEncoding: %q encoded to %q, which can't be stored", segment, name)
+			}
+			decoded, err := encoding.DecodeSegment(name)
+			if err != nil || decoded != segment {
+				t.Errorf("TestKeyEncoding: %q encoded to %q, decoded to %q (%v)", segment, name, decoded, err)
+			}
+		}
+	}
+	if name := PercentEncoding.EncodeSegment("user_1.a-b~c"); name != "user_1.a-b~c" {
+		t.Errorf("TestKeyEncoding: expected unreserved characters to be kept, got %q", name)
+	}
+}
+
+func TestKV_KeyEncoding(t *testing.T) {
+	for _, encoding := range []KeyEncoding{PercentEncoding, Base32Encoding} {
+		kv, cleanup := newTestKV(t)
+		kv.KeyEncoding = encoding
+		keys := []string{"users/alice@example.com", "urls/https:/example.com/a?b=c", "urls/data", "../café"}
+		for _, key := range keys {
+			err := kv.Put(key, []byte(key))
+			if err != nil {
+				t.Fatalf("TestKV_KeyEncoding: failed to put %q (%s)", key, err)
+			}
+			value, err := kv.Get(key)
+			if err != nil || string(value) != key {
+				t.Errorf("TestKV_KeyEncoding: expected %q, got %q (%v)", key, value, err)
+			}
+			info, err := kv.GetInfo(key)
+			if err != nil || info.Path != key {
+				t.Errorf("TestKV_KeyEncoding: expected the info of %q, got %+v (%v)", key, info, err)
+			}
+		}
+
+		entries, err := kv.List("urls")
+		if err != nil {
+			t.Fatalf("TestKV_KeyEncoding: failed to list (%s)", err)
+		}
+		expected := map[string]EntryKind{"https:": PrefixEntry, "data": KeyEntry}
+		for _, entry := range entries {
+			if kind, ok := expected[entry.Name]; !ok || kind != entry.Kind {
+				t.Errorf("TestKV_KeyEncoding: unexpected entry %+v", entry)
+			}
+		}
+		if len(entries) != len(expected) {
+			t.Errorf("TestKV_KeyEncoding: expected %d entries, got %+v", len(expected), entries)
+		}
+
+		var walked []string
+		err = kv.Walk("", func(key string) error {
+			walked = append(walked, key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("TestKV_KeyEncoding: failed to walk (%s)", err)
+		}
+		if len(walked) != len(keys) {
+			t.Errorf("TestKV_KeyEncoding: expected %v, got %v", keys, walked)
+		}
+
+		err = kv.Copy("urls/data", "urls/info")
+		if err != nil {
+			t.Errorf("TestKV_KeyEncoding: failed to copy (%s)", err)
+		}
+		found, err := kv.Exist("urls/info")
+		if err != nil || !found {
+			t.Errorf("TestKV_KeyEncoding: expected the copy to exist (%v)", err)
+		}
+		err = kv.Delete("urls")
+		if err != nil {
+			t.Errorf("TestKV_KeyEncoding: failed to delete (%s)", err)
+		}
+		found, err = kv.Exist("urls/data")
+		if err != nil || found {
+			t.Errorf("TestKV_KeyEncoding: expected urls/data to be deleted (%v)", err)
+		}
+		cleanup()
+	}
+}
+
+func TestKV_KeyEncodingStorage(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	kv.KeyEncoding = PercentEncoding
+	err := kv.Put("users/alice@example.com/..", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestKV_KeyEncodingStorage: failed to put (%s)", err)
+	}
+	found, err := kv.Backend.Exist("users/alice%40example.com/%2E./data")
+	if err != nil || !found {
+		t.Errorf("TestKV_KeyEncodingStorage: expected the key to be stored percent-encoded (%v)", err)
+	}
+
+	// Names the encoding doesn't produce are not keys of kv
+	err = kv.Backend.WriteFile("users/%zz/data", []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := kv.List("users")
+	if err != nil || !reflect.DeepEqual(entries, []Entry{{Name: "alice@example.com", Kind: PrefixEntry}}) {
+		t.Errorf("TestKV_KeyEncodingStorage: unexpected entries %+v (%v)", entries, err)
+	}
+
+	_, err = kv.ParseKey(strings.Repeat("?", MaxSegmentLength/3+1))
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("TestKV_KeyEncodingStorage: expected the encoded segment to be too long, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package multikv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+type ConflictPolicy int
+
+const (
+	// ConflictSkip keeps the existing key
+	ConflictSkip ConflictPolicy = iota
+	// ConflictOverwrite replaces the existing key
+	ConflictOverwrite
+	// ConflictNewer replaces the existing key only if the imported one was updated more recently
+	ConflictNewer
+	// ConflictFail aborts the import
+	ConflictFail
+)
+
+// exportRecord is a line of an export: a key with its info and its base64-encoded value (which is
+// how the data file stores it already)
+type exportRecord struct {
+	Path  string `json:"path"`
+	Info  Info   `json:"info"`
+	Value string `json:"value"`
+}
+
+type ImportResult struct {
+	Imported []string
+	Skipped  []string
+}
+
+// Export writes every key under prefix to w as JSON lines, one key per line. Keys are streamed one at
+// a time, so the store doesn't need to fit in memory.
+func (kv *KV) Export(prefix string, w io.Writer) (err error) {
+	backend, op := kv.begin(context.Background(), "Export", prefix)
+	defer func() { op.end(0, err) }()
+	prefix, err = kv.prefixPath(prefix)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(context.Background(), auth.Read, "Export", prefix)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	return kv.walk(backend, prefix, func(key string) error {
+		info, err := getInfo(backend, kv.storedPath(key))
+		if err != nil {
+			return fmt.Errorf("failed to read info file of %s (%s)", key, err)
+		}
+		data, err := backend.ReadFile(kv.keyFile(key, "data"))
+		if err != nil {
+			return fmt.Errorf("failed to read data file of %s (%s)", key, err)
+		}
+		err = enc.Encode(exportRecord{Path: key, Info: info, Value: string(data)})
+		if err != nil {
+			return fmt.Errorf("failed to write %s (%s)", key, err)
+		}
+		return nil
+	})
+}
+
+// Import restores the keys of an export read from r, with their original info. policy decides what
+// happens with keys that already exist, which get the generation after their current one. Every key
+// written is audited as a Put.
+func (kv *KV) Import(r io.Reader, policy ConflictPolicy) (result ImportResult, err error) {
+	backend, op := kv.begin(context.Background(), "Import", "")
+	defer func() { op.end(0, err) }()
+	dec := json.NewDecoder(r)
+	for {
+		record := exportRecord{}
+		err = dec.Decode(&record)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to parse export (%s)", err)
+		}
+		if record.Path == "" {
+			return result, fmt.Errorf("failed to parse export (record without path)")
+		}
+		record.Path, err = kv.keyPath(record.Path)
+		if err != nil {
+			return result, err
+		}
+		record.Info.Path = record.Path
+		err = kv.authorize(context.Background(), auth.Write, "Import", record.Path)
+		if err != nil {
+			return result, err
+		}
+		value, err := base64.StdEncoding.DecodeString(record.Value)
+		if err != nil {
+			return result, fmt.Errorf("failed to decode value of %s (%s)", record.Path, err)
+		}
+		write, err := kv.resolveConflict(backend, record, policy)
+		if err != nil {
+			return result, err
+		}
+		if !write {
+			result.Skipped = append(result.Skipped, record.Path)
+			continue
+		}
+		oldChecksum, err := kv.auditChecksum(backend, record.Path)
+		if err != nil {
+			return result, err
+		}
+		// Overwriting a key keeps the archived CreatedAt, but must still be seen as a change by
+		// generation checks
+		record.Info, err = nextGeneration(backend, kv.storedPath(record.Path), record.Info)
+		if err != nil {
+			return result, fmt.Errorf("failed to read info file of %s (%s)", record.Path, err)
+		}
+		err = backend.WriteFile(kv.keyFile(record.Path, "data"), []byte(record.Value))
+		if err != nil {
+			return result, fmt.Errorf("failed to write data file of %s (%s)", record.Path, err)
+		}
+		infoJSON, err := json.Marshal(&record.Info)
+		if err != nil {
+			return result, fmt.Errorf("failed to generate info file of %s (%s)", record.Path, err)
+		}
+		err = backend.WriteFile(kv.keyFile(record.Path, "info"), infoJSON)
+		if err != nil {
+			return result, fmt.Errorf("failed to write info file of %s (%s)", record.Path, err)
+		}
+		err = kv.audit(context.Background(), AuditRecord{Operation: "Put", Path: record.Path, OldChecksum: oldChecksum, NewChecksum: checksum(value)})
+		if err != nil {
+			return result, err
+		}
+		result.Imported = append(result.Imported, record.Path)
+	}
+}
+
+// resolveConflict returns whether record should be written according to policy
+func (kv *KV) resolveConflict(backend backends.KvBackend, record exportRecord, policy ConflictPolicy) (bool, error) {
+	found, err := backend.Exist(kv.keyFile(record.Path, "data"))
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s (%s)", record.Path, err)
+	}
+	if !found {
+		return true, nil
+	}
+	switch policy {
+	case ConflictOverwrite:
+		return true, nil
+	case ConflictNewer:
+		existing, err := getInfo(backend, kv.storedPath(record.Path))
+		if err != nil {
+			// Existing key without a readable info file, the imported one is better
+			return true, nil
+		}
+		return record.Info.UpdatedAt.After(existing.UpdatedAt), nil
+	case ConflictFail:
+		return false, fmt.Errorf("key %s already exists", record.Path)
+	default:
+		return false, nil
+	}
+}
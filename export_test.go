@@ -0,0 +1,108 @@
+package multikv
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	src, cleanupSrc := newTestKV(t)
+	defer cleanupSrc()
+	dst, cleanupDst := newTestKV(t)
+	defer cleanupDst()
+	for _, path := range []string{"test/a", "test/b", "other"} {
+		err := src.Put(path, []byte(path))
+		if err != nil {
+			t.Fatalf("TestExportImport: Put should have succeeded (%s)", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := src.Export("test", &buf)
+	if err != nil {
+		t.Fatalf("TestExportImport: Export should not have failed (%s)", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("TestExportImport: expected 2 exported keys, got %d", lines)
+	}
+
+	result, err := dst.Import(&buf, ConflictFail)
+	if err != nil {
+		t.Fatalf("TestExportImport: Import should not have failed (%s)", err)
+	}
+	expected := []string{"test/a", "test/b"}
+	if !reflect.DeepEqual(result.Imported, expected) {
+		t.Errorf("TestExportImport: imported keys did not match. Expected: %v; Found: %v", expected, result.Imported)
+	}
+	data, err := dst.Get("test/b")
+	if err != nil || string(data) != "test/b" {
+		t.Errorf("TestExportImport: imported value is different from original (got '%s', %v)", data, err)
+	}
+	srcInfo, _ := src.GetInfo("test/a")
+	dstInfo, err := dst.GetInfo("test/a")
+	if err != nil || !dstInfo.CreatedAt.Equal(srcInfo.CreatedAt) || dstInfo.Generation != srcInfo.Generation {
+		t.Errorf("TestExportImport: info should have been preserved (expected %+v got %+v)", srcInfo, dstInfo)
+	}
+}
+
+func TestImport_ConflictPolicy(t *testing.T) {
+	src, cleanupSrc := newTestKV(t)
+	defer cleanupSrc()
+	err := src.Put("key", []byte("exported"))
+	if err != nil {
+		t.Fatalf("TestImport_ConflictPolicy: Put should have succeeded (%s)", err)
+	}
+	var buf bytes.Buffer
+	err = src.Export("", &buf)
+	if err != nil {
+		t.Fatalf("TestImport_ConflictPolicy: Export should not have failed (%s)", err)
+	}
+	export := buf.String()
+
+	tests := []struct {
+		policy     ConflictPolicy
+		expected   string
+		generation int64
+		fails      bool
+	}{
+		{ConflictSkip, "existing", 1, false},
+		// Overwritten keys never go back to an older generation
+		{ConflictOverwrite, "exported", 2, false},
+		{ConflictNewer, "existing", 1, false},
+		{ConflictFail, "existing", 1, true},
+	}
+	for _, test := range tests {
+		dst, cleanupDst := newTestKV(t)
+		// Written after the export, so it is the newer one
+		err = dst.Put("key", []byte("existing"))
+		if err != nil {
+			t.Fatalf("TestImport_ConflictPolicy: Put should have succeeded (%s)", err)
+		}
+		_, err = dst.Import(strings.NewReader(export), test.policy)
+		if (err != nil) != test.fails {
+			t.Errorf("TestImport_ConflictPolicy: unexpected error for policy %d (%v)", test.policy, err)
+		}
+		data, _ := dst.Get("key")
+		if string(data) != test.expected {
+			t.Errorf("TestImport_ConflictPolicy: expected '%s' for policy %d, got '%s'", test.expected, test.policy, data)
+		}
+		info, err := dst.GetInfo("key")
+		if err != nil || info.Generation != test.generation {
+			t.Errorf("TestImport_ConflictPolicy: expected generation %d for policy %d, got %+v (%v)", test.generation, test.policy, info, err)
+		}
+		cleanupDst()
+	}
+}
+
+func TestImport_Invalid(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	for _, export := range []string{"not json", `{"path":"key","value":"!!"}`, `{"value":"dGVzdA=="}`} {
+		_, err := kv.Import(strings.NewReader(export), ConflictOverwrite)
+		if err == nil {
+			t.Errorf("TestImport_Invalid: should have failed for %s", export)
+		}
+	}
+}
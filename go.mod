@@ -4,6 +4,11 @@ go 1.16
 
 require (
 	cloud.google.com/go/storage v1.16.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	golang.org/x/sys v0.1.0
 	google.golang.org/api v0.51.0
+	google.golang.org/grpc v1.39.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,15 @@
+// Package logfmt formats the key=value pairs of the log lines written by multikv
+package logfmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Value quotes s if it can't be told apart from the other pairs otherwise
+func Value(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=") || strconv.Quote(s) != "\""+s+"\"" {
+		return strconv.Quote(s)
+	}
+	return s
+}
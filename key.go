@@ -0,0 +1,139 @@
+package multikv
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidKey is wrapped by the errors returned for keys that can't be stored
+var ErrInvalidKey = errors.New("invalid key")
+
+const (
+	// MaxKeyLength is the maximum length of a key in bytes, which leaves room for the name of its
+	// files within the 1024 bytes of GCS object names
+	MaxKeyLength = 1000
+	// MaxSegmentLength is the maximum length of each segment of a key in bytes, like file names
+	MaxSegmentLength = 255
+)
+
+// keyPunctuation are the characters allowed in keys besides letters and digits
+const keyPunctuation = "-_.~@+=,"
+
+// internalPrefix starts the names of the files backends keep for themselves, which they hide from
+// listings (e.g. the temporary files of the local backend and the journal of the tiered one)
+const internalPrefix = ".multikv-"
+
+// Key is a key in its canonical form: segments separated by single forward slashes, on every OS
+// and backend, without leading or trailing slashes. The empty Key is the root of the store, which is
+// only valid as a prefix.
+type Key string
+
+// ParseKey validates path and returns its canonical form. Keys are made of letters, digits and the
+// characters -_.~@+=, separated by slashes. Their segments can't be "." or "..", nor the names of the
+// files of a key ("data" and "info") or of the snapshots of a store or Sub (".snapshots"), and can't
+// start with ".multikv-", which backends use for their own files. Invalid keys fail with an error
+// wrapping ErrInvalidKey.
+func ParseKey(path string) (Key, error) {
+	key, err := ParsePrefix(path)
+	if err == nil && key == "" {
+		return "", fmt.Errorf("%w %q (empty key)", ErrInvalidKey, path)
+	}
+	return key, err
+}
+
+// ParsePrefix is like ParseKey, but also accepts the root of the store ("" or "/")
+func ParsePrefix(path string) (Key, error) {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		// Repeated slashes are collapsed
+		if segment == "" {
+			continue
+		}
+		reason := invalidSegment(segment)
+		if reason != "" {
+			return "", fmt.Errorf("%w %q (%s)", ErrInvalidKey, path, reason)
+		}
+		segments = append(segments, segment)
+	}
+	key := strings.Join(segments, "/")
+	if len(key) > MaxKeyLength {
+		return "", fmt.Errorf("%w %q (longer than %d bytes)", ErrInvalidKey, path, MaxKeyLength)
+	}
+	return Key(key), nil
+}
+
+// invalidSegment returns why segment isn't valid, or an empty string if it is
+func invalidSegment(segment string) string {
+	switch {
+	case segment == "." || segment == "..":
+		return fmt.Sprintf("segment %q is not allowed", segment)
+	case segment == "data" || segment == "info" || segment == snapshotsDir:
+		return fmt.Sprintf("segment %q is reserved", segment)
+	case strings.HasPrefix(segment, internalPrefix):
+		return fmt.Sprintf("prefix %q is reserved", internalPrefix)
+	case len(segment) > MaxSegmentLength:
+		return fmt.Sprintf("segment longer than %d bytes", MaxSegmentLength)
+	case !utf8.ValidString(segment):
+		return "not valid UTF-8"
+	}
+	for _, r := range segment {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(keyPunctuation, r) {
+			return fmt.Sprintf("character %q is not allowed", r)
+		}
+	}
+	return ""
+}
+
+func (k Key) String() string {
+	return string(k)
+}
+
+// Segments returns the segments of k, none for the root
+func (k Key) Segments() []string {
+	if k == "" {
+		return nil
+	}
+	return strings.Split(string(k), "/")
+}
+
+// ParseKey is like the ParseKey function, but follows the KeyEncoding of kv: with one, any byte
+// string is a valid key, and slashes still separate its segments
+func (kv *KV) ParseKey(path string) (Key, error) {
+	key, err := kv.ParsePrefix(path)
+	if err == nil && key == "" {
+		return "", fmt.Errorf("%w %q (empty key)", ErrInvalidKey, path)
+	}
+	return key, err
+}
+
+// ParsePrefix is like the ParsePrefix function, but follows the KeyEncoding of kv
+func (kv *KV) ParsePrefix(path string) (Key, error) {
+	if kv.err != nil {
+		return "", kv.err
+	}
+	if kv.KeyEncoding == nil {
+		return ParsePrefix(path)
+	}
+	return parseEncodedPrefix(kv.KeyEncoding, path)
+}
+
+// keyPath validates a key passed to kv, and returns its canonical form
+func (kv *KV) keyPath(path string) (string, error) {
+	key, err := kv.ParseKey(path)
+	return string(key), err
+}
+
+// prefixPath is keyPath for prefixes
+func (kv *KV) prefixPath(path string) (string, error) {
+	key, err := kv.ParsePrefix(path)
+	return string(key), err
+}
+
+// joinKey joins the elements of a path in the backend with forward slashes, whatever the OS
+func joinKey(elem ...string) string {
+	return path.Join(elem...)
+}
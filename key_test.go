@@ -0,0 +1,82 @@
+package multikv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected Key
+		valid    bool
+	}{
+		{"test/key", "test/key", true},
+		{"/test//key/", "test/key", true},
+		{"users/alice@example.com", "users/alice@example.com", true},
+		{"café/v1.2_3-4~5+6=7,8", "café/v1.2_3-4~5+6=7,8", true},
+		{"", "", false},
+		{"/", "", false},
+		{"../etc/cron.d/x", "", false},
+		{"a/./b", "", false},
+		{"a/data", "", false},
+		{"a/info/b", "", false},
+		{".snapshots/a", "", false},
+		{"a/.snapshots", "", false},
+		{".multikv-tmp-x", "", false},
+		{"a/.multikv-tiered-journal", "", false},
+		{"a/x.multikv-tmp-", "a/x.multikv-tmp-", true},
+		{`a\b`, "", false},
+		{"a b", "", false},
+		{"a?b", "", false},
+		{"a\x00b", "", false},
+		{"a/\xff", "", false},
+		{strings.Repeat("a", MaxSegmentLength+1), "", false},
+		{strings.Repeat("a/", MaxKeyLength/2+1), "", false},
+	}
+	for _, test := range tests {
+		key, err := ParseKey(test.path)
+		if test.valid && (err != nil || key != test.expected) {
+			t.Errorf("TestParseKey: %q: expected %q, got %q (%v)", test.path, test.expected, key, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("TestParseKey: %q: expected an invalid key error, got %q (%v)", test.path, key, err)
+		}
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	for _, path := range []string{"", "/", "//"} {
+		key, err := ParsePrefix(path)
+		if err != nil || key != "" {
+			t.Errorf("TestParsePrefix: %q: expected the root, got %q (%v)", path, key, err)
+		}
+	}
+	key, err := ParsePrefix("a//b/")
+	if err != nil || !reflect.DeepEqual(key.Segments(), []string{"a", "b"}) {
+		t.Errorf("TestParsePrefix: expected segments a and b, got %v (%v)", key.Segments(), err)
+	}
+}
+
+func TestKV_InvalidKey(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("../outside", []byte("test"))
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("TestKV_InvalidKey: Put should have failed, got %v", err)
+	}
+	err = kv.Put("/test//key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestKV_InvalidKey: Put should have succeeded (%s)", err)
+	}
+	info, err := kv.GetInfo("test/key")
+	if err != nil || info.Path != "test/key" {
+		t.Errorf("TestKV_InvalidKey: expected the key to be stored at test/key, got %+v (%v)", info, err)
+	}
+	err = kv.Copy("test", "test/key/copy")
+	if err == nil {
+		t.Errorf("TestKV_InvalidKey: overlapping Copy should have failed")
+	}
+}
@@ -1,17 +1,41 @@
 package multikv
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/marcelocarlos/multikv/auth"
 	"github.com/marcelocarlos/multikv/backends"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type KV struct {
 	Backend backends.KvBackend
+	// Tracer, if set, records a span for every operation reading or writing keys (Put, Get, List,
+	// Copy, Snapshot...), with a child span for every backend call. Their Context variants (e.g.
+	// GetContext) make the spans children of the span in the context.
+	Tracer trace.Tracer
+	// Metrics, if set, records statistics about the same operations, see Stats
+	Metrics *Metrics
+	// Authorizer, if set, checks every operation against its policy, as the principal in the context
+	// of the operation (see auth.WithPrincipal). Methods without a Context variant are anonymous.
+	Authorizer *auth.Authorizer
+	// Audit, if set, records every Put, Delete, Copy and Move, see AuditRecord. Auditing reads the
+	// previous value of the keys changed, to record its checksum.
+	Audit AuditSink
+	// KeyEncoding, if set, encodes the segments of keys before they reach the backend, so that keys can
+	// be any byte string (see KV.ParseKey). It can't be changed once keys are stored.
+	KeyEncoding KeyEncoding
+
+	// root is the path in the backend of the prefix of a Sub, prefix its key, and err the error of an
+	// invalid prefix
+	root   string
+	prefix string
+	err    error
 }
 
 type Info struct {
@@ -20,6 +44,7 @@ type Info struct {
 	Path          string    `yaml:"path"`
 	CreatedAt     time.Time `yaml:"createdAt"`
 	UpdatedAt     time.Time `yaml:"updatedAt"`
+	Generation    int64     `yaml:"generation"`
 }
 
 func (kv *KV) NewInfo(path string) Info {
@@ -29,40 +54,91 @@ func (kv *KV) NewInfo(path string) Info {
 		Path:          path,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
+		Generation:    1,
 	}
 }
 
 func (kv *KV) Put(path string, value []byte) error {
-	// Info File
-	info := Info{}
-	infoFile, err := kv.Backend.ReadFile(filepath.Join(path, "info"))
+	return kv.PutContext(context.Background(), path, value)
+}
+
+func (kv *KV) PutContext(ctx context.Context, path string, value []byte) (err error) {
+	backend, op := kv.begin(ctx, "Put", path)
+	defer func() { op.end(len(value), err) }()
+	path, err = kv.keyPath(path)
 	if err != nil {
-		info = kv.NewInfo(path)
-	} else {
+		return err
+	}
+	err = kv.authorize(ctx, auth.Write, "Put", path)
+	if err != nil {
+		return err
+	}
+	return kv.put(ctx, backend, path, value)
+}
+
+// put writes value to the key path, which has been validated and authorized
+func (kv *KV) put(ctx context.Context, backend backends.KvBackend, path string, value []byte) error {
+	oldChecksum, err := kv.auditChecksum(backend, path)
+	if err != nil {
+		return err
+	}
+	// Data File - written before the info file so that a reader that sees a new generation in the
+	// info file is guaranteed to find the matching data (see CachedKV)
+	err = backend.WriteFile(kv.keyFile(path, "data"), []byte(base64.StdEncoding.EncodeToString(value)))
+	if err != nil {
+		return err
+	}
+	err = updateInfo(backend, kv.storedPath(path), kv.NewInfo(path))
+	if err != nil || kv.Audit == nil {
+		return err
+	}
+	return kv.audit(ctx, AuditRecord{Operation: "Put", Path: path, OldChecksum: oldChecksum, NewChecksum: checksum(value)})
+}
+
+// updateInfo creates the info file of the key stored at dir from info, or bumps its generation if it
+// already exists
+func updateInfo(backend backends.KvBackend, dir string, info Info) error {
+	infoFile, err := backend.ReadFile(joinKey(dir, "info"))
+	if err == nil {
+		info = Info{}
 		err = json.Unmarshal([]byte(infoFile), &info)
 		if err != nil {
 			return fmt.Errorf("failed to parse info file (%s)", err)
 		}
 		info.UpdatedAt = time.Now()
+		info.Generation++
 	}
 	infoJSON, err := json.Marshal(&info)
 	if err != nil {
 		return fmt.Errorf("failed to generate info file (%s)", err)
 	}
-	err = kv.Backend.WriteFile(filepath.Join(path, "info"), infoJSON)
+	err = backend.WriteFile(joinKey(dir, "info"), infoJSON)
 	if err != nil {
 		return fmt.Errorf("failed to write info file (%s)", err)
 	}
-	// Data File
-	return kv.Backend.WriteFile(filepath.Join(path, "data"), []byte(base64.StdEncoding.EncodeToString(value)))
+	return nil
 }
 
 func (kv *KV) Get(path string) ([]byte, error) {
-	data, err := kv.Backend.ReadFile(filepath.Join(path, "data"))
+	return kv.GetContext(context.Background(), path)
+}
+
+func (kv *KV) GetContext(ctx context.Context, path string) (decoded []byte, err error) {
+	backend, op := kv.begin(ctx, "Get", path)
+	defer func() { op.end(len(decoded), err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.authorize(ctx, auth.Read, "Get", path)
 	if err != nil {
 		return nil, err
 	}
-	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	data, err := backend.ReadFile(kv.keyFile(path, "data"))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err = base64.StdEncoding.DecodeString(string(data))
 	if err != nil {
 		return nil, err
 	}
@@ -70,8 +146,46 @@ func (kv *KV) Get(path string) ([]byte, error) {
 }
 
 func (kv *KV) GetInfo(path string) (Info, error) {
+	return kv.GetInfoContext(context.Background(), path)
+}
+
+func (kv *KV) GetInfoContext(ctx context.Context, path string) (info Info, err error) {
+	backend, op := kv.begin(ctx, "GetInfo", path)
+	defer func() { op.end(0, err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return info, err
+	}
+	err = kv.authorize(ctx, auth.Read, "GetInfo", path)
+	if err != nil {
+		return info, err
+	}
+	return getInfo(backend, kv.storedPath(path))
+}
+
+// Exist reports whether path is a key
+func (kv *KV) Exist(path string) (bool, error) {
+	return kv.ExistContext(context.Background(), path)
+}
+
+func (kv *KV) ExistContext(ctx context.Context, path string) (found bool, err error) {
+	backend, op := kv.begin(ctx, "Exist", path)
+	defer func() { op.end(0, err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return false, err
+	}
+	err = kv.authorize(ctx, auth.Read, "Exist", path)
+	if err != nil {
+		return false, err
+	}
+	return backend.Exist(kv.keyFile(path, "data"))
+}
+
+// getInfo reads the info of the key stored at dir
+func getInfo(backend backends.KvBackend, dir string) (Info, error) {
 	info := Info{}
-	infoFile, err := kv.Backend.ReadFile(filepath.Join(path, "info"))
+	infoFile, err := backend.ReadFile(joinKey(dir, "info"))
 	if err != nil {
 		return info, err
 	}
@@ -79,23 +193,258 @@ func (kv *KV) GetInfo(path string) (Info, error) {
 	return info, err
 }
 
+// nextGeneration gives info, about to be written over the key stored at dir, the generation after
+// the one of that key, so that generation checks (PutIf, If-Match) see the write. info is kept as it
+// is when there is no such key, or its info file can't be parsed.
+func nextGeneration(backend backends.KvBackend, dir string, info Info) (Info, error) {
+	infoPath := joinKey(dir, "info")
+	found, err := backend.Exist(infoPath)
+	if err != nil || !found {
+		return info, err
+	}
+	infoFile, err := backend.ReadFile(infoPath)
+	if err != nil {
+		return info, err
+	}
+	existing := Info{}
+	if json.Unmarshal(infoFile, &existing) == nil {
+		info.Generation = existing.Generation + 1
+	}
+	return info, nil
+}
+
 func (kv *KV) Delete(path string) error {
-	return kv.Backend.DeleteDir(path)
+	return kv.DeleteContext(context.Background(), path)
 }
 
-func (kv *KV) List(path string) ([]string, error) {
-	dirList, err := kv.Backend.ListDir(path)
+func (kv *KV) DeleteContext(ctx context.Context, path string) (err error) {
+	backend, op := kv.begin(ctx, "Delete", path)
+	defer func() { op.end(0, err) }()
+	path, err = kv.keyPath(path)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(ctx, auth.Write, "Delete", path)
+	if err != nil {
+		return err
+	}
+	if kv.Audit == nil {
+		return backend.DeleteDir(kv.storedPath(path))
+	}
+	// Every key deleted is recorded, with its last value
+	var records []AuditRecord
+	err = kv.walk(backend, path, func(key string) error {
+		oldChecksum, err := storedChecksum(backend, kv.storedPath(key))
+		records = append(records, AuditRecord{Operation: "Delete", Path: key, OldChecksum: oldChecksum})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = backend.DeleteDir(kv.storedPath(path))
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		err = kv.audit(ctx, record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type EntryKind int
+
+const (
+	// KeyEntry is a key, whose value can be read with Get
+	KeyEntry EntryKind = iota
+	// PrefixEntry is a directory holding other keys, which can be listed in turn
+	PrefixEntry
+)
+
+type Entry struct {
+	Name string
+	Kind EntryKind
+	// Info is only set for keys, when listing with ListOptions.WithInfo
+	Info *Info
+}
+
+type ListOptions struct {
+	// WithInfo loads the info of every key listed
+	WithInfo bool
+}
+
+func (kv *KV) List(path string) ([]Entry, error) {
+	return kv.ListContext(context.Background(), path, ListOptions{})
+}
+
+func (kv *KV) ListWithOptions(path string, opts ListOptions) ([]Entry, error) {
+	return kv.ListContext(context.Background(), path, opts)
+}
+
+// ListContext returns the keys and prefixes directly under path. Each entry costs a single lookup
+// in the backend: an Exist for its data file, or reading its info file when WithInfo is set.
+func (kv *KV) ListContext(ctx context.Context, path string, opts ListOptions) (entries []Entry, err error) {
+	backend, op := kv.begin(ctx, "List", path)
+	defer func() { op.end(0, err) }()
+	path, err = kv.prefixPath(path)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.authorize(ctx, auth.List, "List", path)
+	if err != nil {
+		return nil, err
+	}
+	dirList, err := backend.ListDir(kv.storedPath(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read path %s (%s)", path, err)
 	}
-	var keys []string
+	return kv.listEntries(backend, path, dirList, opts)
+}
+
+// ListPage returns up to pageSize entries of prefix, starting after cursor (empty for the first
+// page), and the cursor of the next page, which is empty after the last one. Cursors are opaque and
+// specific to the backend. Pages can be shorter than pageSize even when more entries follow.
+func (kv *KV) ListPage(prefix string, pageSize int, cursor string) ([]Entry, string, error) {
+	return kv.ListPageContext(context.Background(), prefix, pageSize, cursor)
+}
+
+func (kv *KV) ListPageContext(ctx context.Context, prefix string, pageSize int, cursor string) (entries []Entry, next string, err error) {
+	backend, op := kv.begin(ctx, "ListPage", prefix)
+	defer func() { op.end(0, err) }()
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("page size must be positive")
+	}
+	prefix, err = kv.prefixPath(prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	err = kv.authorize(ctx, auth.List, "List", prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	var dirList []string
+	if pager, ok := backend.(backends.Pager); ok && kv.Capabilities().Page {
+		dirList, next, err = pager.ListDirPage(kv.storedPath(prefix), pageSize, cursor)
+	} else {
+		dirList, err = backend.ListDir(kv.storedPath(prefix))
+		dirList, next = backends.PageNames(dirList, pageSize, cursor)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read path %s (%s)", prefix, err)
+	}
+	entries, err = kv.listEntries(backend, prefix, dirList, ListOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	return entries, next, nil
+}
+
+// listEntries turns the names listed under path into entries
+func (kv *KV) listEntries(backend backends.KvBackend, path string, dirList []string, opts ListOptions) ([]Entry, error) {
+	var entries []Entry
 	for _, f := range dirList {
-		dataFileFound, _ := kv.Backend.Exist(filepath.Join(path, "data"))
-		infoFileFound, _ := kv.Backend.Exist(filepath.Join(path, "info"))
-		if dataFileFound || infoFileFound {
+		if !strings.HasSuffix(f, "/") {
+			// Only keys have files directly under them (their data and info files)
 			return nil, fmt.Errorf("cannot list the contents of a key, use Get or GetInfo instead")
 		}
-		keys = append(keys, f)
+		stored := strings.TrimSuffix(f, "/")
+		name, ok := kv.decodeName(stored)
+		if !ok || stored == snapshotsDir {
+			continue
+		}
+		entry, err := listEntry(backend, joinKey(kv.storedPath(path), stored), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read path %s (%s)", joinKey(path, name), err)
+		}
+		entry.Name = name
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// listEntry returns the entry stored at dir
+func listEntry(backend backends.KvBackend, dir string, opts ListOptions) (Entry, error) {
+	if opts.WithInfo {
+		info, err := getInfo(backend, dir)
+		if err == nil {
+			return Entry{Kind: KeyEntry, Info: &info}, nil
+		}
+		// Only a failed read costs a second lookup, to tell prefixes apart from broken keys
+		isKey, existErr := backend.Exist(joinKey(dir, "data"))
+		if existErr != nil {
+			return Entry{}, existErr
+		}
+		if isKey {
+			return Entry{}, fmt.Errorf("failed to read info file (%s)", err)
+		}
+		return Entry{Kind: PrefixEntry}, nil
+	}
+	isKey, err := backend.Exist(joinKey(dir, "data"))
+	if err != nil {
+		return Entry{}, err
+	}
+	if isKey {
+		return Entry{Kind: KeyEntry}, nil
+	}
+	return Entry{Kind: PrefixEntry}, nil
+}
+
+// Walk calls fn for every key stored under prefix (including prefix itself, if it is a key),
+// descending into sub-directories
+func (kv *KV) Walk(prefix string, fn func(key string) error) (err error) {
+	backend, op := kv.begin(context.Background(), "Walk", prefix)
+	defer func() { op.end(0, err) }()
+	prefix, err = kv.prefixPath(prefix)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(context.Background(), auth.List, "Walk", prefix)
+	if err != nil {
+		return err
+	}
+	return kv.walk(backend, prefix, fn)
+}
+
+func (kv *KV) walk(backend backends.KvBackend, prefix string, fn func(key string) error) error {
+	isKey, err := backend.Exist(kv.keyFile(prefix, "data"))
+	if err != nil {
+		return fmt.Errorf("failed to read path %s (%s)", prefix, err)
+	}
+	if isKey {
+		err = fn(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	dirList, err := backend.ListDir(kv.storedPath(prefix))
+	if err != nil {
+		return fmt.Errorf("failed to read path %s (%s)", prefix, err)
+	}
+	for _, f := range dirList {
+		// Files are the data and info files of prefix itself
+		if !strings.HasSuffix(f, "/") {
+			continue
+		}
+		stored := strings.TrimSuffix(f, "/")
+		name, ok := kv.decodeName(stored)
+		if !ok || stored == snapshotsDir {
+			continue
+		}
+		err = kv.walk(backend, joinKey(prefix, name), fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize checks that the principal in ctx has perm on path, when kv has an Authorizer. Paths are
+// checked from the root of the store, whatever Sub kv is.
+func (kv *KV) authorize(ctx context.Context, perm auth.Permission, op string, path string) error {
+	if kv.Authorizer == nil {
+		return nil
 	}
-	return keys, nil
+	return kv.Authorizer.Authorize(ctx, perm, op, joinKey(kv.prefix, path))
 }
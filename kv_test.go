@@ -2,14 +2,19 @@ package multikv
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/marcelocarlos/multikv/auth"
 	"github.com/marcelocarlos/multikv/backends/local"
 )
 
@@ -194,12 +199,183 @@ func TestList(t *testing.T) {
 		t.Errorf("TestList: Put should have succeeded")
 	}
 
-	keys, err := kv.List("test/sub")
+	err = kv.Put("test/sub/other/key", []byte("test"))
+	if err != nil {
+		t.Errorf("TestList: Put should have succeeded")
+	}
+
+	entries, err := kv.List("test/sub")
 	if err != nil {
 		t.Errorf("TestList: List should have succeeded")
 	}
-	expected := []string{"key"}
+	expected := []Entry{{Name: "key", Kind: KeyEntry}, {Name: "other", Kind: PrefixEntry}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("TestList: listed keys did not match. Expected: %v; Found: %v", expected, entries)
+	}
+
+	_, err = kv.List(path)
+	if err == nil {
+		t.Errorf("TestList: listing a key should have failed")
+	}
+}
+
+func TestListWithOptions(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestListWithOptions: Put should have succeeded (%s)", err)
+	}
+	err = kv.Put("test/sub/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestListWithOptions: Put should have succeeded (%s)", err)
+	}
+
+	entries, err := kv.ListWithOptions("test", ListOptions{WithInfo: true})
+	if err != nil {
+		t.Fatalf("TestListWithOptions: should not have failed (%s)", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("TestListWithOptions: expected 2 entries, got %v", entries)
+	}
+	if entries[0].Kind != KeyEntry || entries[0].Info == nil || entries[0].Info.Path != "test/key" {
+		t.Errorf("TestListWithOptions: expected key entry with info, got %+v", entries[0])
+	}
+	if entries[1].Kind != PrefixEntry || entries[1].Info != nil {
+		t.Errorf("TestListWithOptions: expected prefix entry without info, got %+v", entries[1])
+	}
+
+	// A key whose info can't be read is an error, not a prefix
+	err = kv.Backend.DeleteFile("test/key/info")
+	if err != nil {
+		t.Fatalf("TestListWithOptions: failed to prepare (%s)", err)
+	}
+	_, err = kv.ListWithOptions("test", ListOptions{WithInfo: true})
+	if err == nil {
+		t.Errorf("TestListWithOptions: should have failed for a key without info")
+	}
+}
+
+func TestListPage(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	for _, path := range []string{"test/a", "test/b", "test/c", "test/d/key", "test/e"} {
+		err := kv.Put(path, []byte("test"))
+		if err != nil {
+			t.Fatalf("TestListPage: Put should have succeeded (%s)", err)
+		}
+	}
+	// Paginated natively by the local backend, and with the fallback over ListDir
+	for _, store := range []KV{kv, {Backend: basicBackend{kv.Backend}}} {
+		var names []string
+		cursor := ""
+		pages := 0
+		for {
+			entries, next, err := store.ListPage("test", 2, cursor)
+			if err != nil {
+				t.Fatalf("TestListPage: should not have failed (%s)", err)
+			}
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			pages++
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		expected := []string{"a", "b", "c", "d", "e"}
+		if !reflect.DeepEqual(names, expected) || pages != 3 {
+			t.Errorf("TestListPage: expected %v in 3 pages, got %v in %d pages", expected, names, pages)
+		}
+	}
+
+	_, _, err := kv.ListPage("test", 0, "")
+	if err == nil {
+		t.Errorf("TestListPage: should have failed with an invalid page size")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+	backend, err := local.NewLocalBackend(baseDir)
+	if err != nil {
+		t.Errorf("TestWalk: failed to prepare (%s)", err)
+	}
+	kv := KV{Backend: backend}
+	for _, path := range []string{"test/a", "test/a/b", "test/sub/c", "other"} {
+		err = kv.Put(path, []byte("test"))
+		if err != nil {
+			t.Errorf("TestWalk: Put should have succeeded")
+		}
+	}
+
+	var keys []string
+	err = kv.Walk("test", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("TestWalk: Walk should have succeeded (%s)", err)
+	}
+	expected := []string{"test/a", "test/a/b", "test/sub/c"}
 	if !reflect.DeepEqual(keys, expected) {
-		t.Errorf("TestList: listed keys did not match. Expected: %v; Found: %v", expected, keys)
+		t.Errorf("TestWalk: walked keys did not match. Expected: %v; Found: %v", expected, keys)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	var logs bytes.Buffer
+	kv.Authorizer = &auth.Authorizer{
+		Policy: &auth.Policy{Rules: []auth.Rule{
+			{Principals: []string{"*"}, Prefix: "public", Permissions: []auth.Permission{auth.Read, auth.List}},
+			{Principals: []string{"alice"}, Prefix: "", Permissions: []auth.Permission{auth.Read, auth.Write, auth.List}},
+		}},
+		Log: log.New(&logs, "", 0),
+	}
+	alice := auth.WithPrincipal(context.Background(), "alice")
+	bob := auth.WithPrincipal(context.Background(), "bob")
+	for _, path := range []string{"public/a", "secrets/db"} {
+		err := kv.PutContext(alice, path, []byte("test"))
+		if err != nil {
+			t.Fatalf("TestAuthorizer: alice should be able to write %s (%s)", path, err)
+		}
+	}
+
+	_, err := kv.GetContext(bob, "public/a")
+	if err != nil {
+		t.Errorf("TestAuthorizer: bob should be able to read public keys (%s)", err)
+	}
+	_, err = kv.GetContext(bob, "secrets/db")
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("TestAuthorizer: bob should not be able to read secrets, got %v", err)
+	}
+	_, err = kv.GetContext(bob, "public/../secrets/db")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("TestAuthorizer: bob should not be able to read secrets through .., got %v", err)
+	}
+	err = kv.PutContext(bob, "public/a", []byte("test"))
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("TestAuthorizer: bob should not be able to write public keys, got %v", err)
+	}
+	// Without a Context variant, operations are anonymous
+	_, err = kv.Get("secrets/db")
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("TestAuthorizer: anonymous Get should have been denied, got %v", err)
+	}
+	err = kv.Copy("secrets", "public/copy")
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("TestAuthorizer: anonymous Copy should have been denied, got %v", err)
+	}
+
+	expected := "principal=bob op=Get permission=read path=secrets/db allowed=false\n"
+	if !strings.Contains(logs.String(), expected) {
+		t.Errorf("TestAuthorizer: expected the denial to be logged, got:\n%s", logs.String())
 	}
 }
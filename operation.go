@@ -0,0 +1,49 @@
+package multikv
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// operation is a KV operation being traced and measured
+type operation struct {
+	name    string
+	start   time.Time
+	span    trace.Span
+	metrics *Metrics
+}
+
+// begin starts a KV operation, and returns the backend to use during the operation, which records a
+// child span for every backend call when kv has a Tracer
+func (kv *KV) begin(ctx context.Context, name string, path string) (backends.KvBackend, *operation) {
+	op := &operation{name: name, start: time.Now(), metrics: kv.Metrics}
+	if kv.Tracer == nil {
+		op.span = trace.SpanFromContext(context.Background())
+		return kv.Backend, op
+	}
+	ctx, op.span = kv.Tracer.Start(ctx, "multikv."+name, trace.WithAttributes(
+		attribute.String("multikv.path", path),
+		attribute.String("multikv.backend", fmt.Sprintf("%T", kv.Backend)),
+	))
+	return middleware.TracingContext(ctx, kv.Tracer, 1)(kv.Backend), op
+}
+
+// end records the size of the value and the outcome of the operation
+func (op *operation) end(size int, err error) {
+	if op.metrics != nil {
+		op.metrics.record(op.name, time.Since(op.start), size, err)
+	}
+	op.span.SetAttributes(attribute.Int("multikv.bytes", size))
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+	}
+	op.span.End()
+}
@@ -0,0 +1,336 @@
+// Package server exposes a KV store over HTTP:
+//
+//	GET    /keys/{path}         value of the key, supports Range requests
+//	GET    /keys/{path}?info    info of the key, as JSON
+//	PUT    /keys/{path}         writes the request body as the value of the key
+//	DELETE /keys/{path}         deletes the key and every key under it
+//	GET    /list/{prefix}       keys and prefixes directly under prefix, as JSON
+//
+// Responses for keys carry the generation of the key as their ETag. PUT and DELETE requests with an
+// If-Match header only succeed if the key is still at that generation (PUT also accepts
+// "If-None-Match: *" to only create keys), and fail with 412 Precondition Failed otherwise. Errors
+// are returned as a JSON object with an "error" field.
+//
+// With WithAuthenticator, requests must authenticate (401 Unauthorized otherwise), and operations run
+// as the authenticated principal, so that the Authorizer of the KV decides what they can do (403
+// Forbidden otherwise).
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marcelocarlos/multikv"
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// MaxValueSize is the largest value accepted by PUT requests
+const MaxValueSize = 64 << 20
+
+type Server struct {
+	kv            *multikv.KV
+	mux           *http.ServeMux
+	authenticator auth.Authenticator
+}
+
+type Option func(*Server)
+
+// WithAuthenticator makes every request authenticate with authenticator
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+func NewServer(kv *multikv.KV, opts ...Option) *Server {
+	s := &Server{kv: kv, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux.HandleFunc("/keys/", s.handleKey)
+	s.mux.HandleFunc("/list/", s.handleList)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.authenticator != nil {
+		principal, err := s.authenticator.Authenticate(auth.CredentialsFromRequest(r))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, errorf(http.StatusUnauthorized, "%s", err))
+			return
+		}
+		r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// httpError is an error with the status code it is returned with
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		status = httpErr.status
+	} else if errors.Is(err, auth.ErrPermissionDenied) {
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	path, err := s.requestPath(r, "/keys/")
+	if err == nil && path == "" {
+		err = errorf(http.StatusBadRequest, "missing key path")
+	}
+	if err == nil {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			err = s.get(w, r, path)
+		case http.MethodPut:
+			err = s.put(w, r, path)
+		case http.MethodDelete:
+			err = s.delete(w, r, path)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			err = errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, path string) error {
+	info, err := s.info(r, path)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag(info.Generation))
+	if _, ok := r.URL.Query()["info"]; ok {
+		writeJSON(w, http.StatusOK, info)
+		return nil
+	}
+	value, err := s.kv.GetContext(r.Context(), path)
+	if err != nil {
+		return errorf(http.StatusInternalServerError, "failed to read key %s (%s)", path, err)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// Handles Range, If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, "", info.UpdatedAt, bytes.NewReader(value))
+	return nil
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, path string) error {
+	value, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxValueSize))
+	if err != nil {
+		return errorf(http.StatusRequestEntityTooLarge, "failed to read value (%s)", err)
+	}
+	generation, conditional, err := s.precondition(r, path)
+	if err != nil {
+		return err
+	}
+	var info multikv.Info
+	if conditional {
+		info, err = s.kv.PutIfContext(r.Context(), path, value, generation)
+		switch {
+		case errors.Is(err, auth.ErrPermissionDenied):
+			return err
+		case err == multikv.ErrGenerationMismatch:
+			return errorf(http.StatusPreconditionFailed, "key %s was modified", path)
+		case err == backends.ErrNotSupported:
+			return errorf(http.StatusNotImplemented, "conditional writes are not supported by the backend")
+		case err != nil:
+			return errorf(http.StatusInternalServerError, "failed to write key %s (%s)", path, err)
+		}
+	} else {
+		err = s.kv.PutContext(r.Context(), path, value)
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			return err
+		}
+		if err != nil {
+			return errorf(http.StatusInternalServerError, "failed to write key %s (%s)", path, err)
+		}
+		info, err = s.kv.GetInfoContext(r.Context(), path)
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			// Write-only principals don't get the generation back
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		if err != nil {
+			return errorf(http.StatusInternalServerError, "failed to read info of key %s (%s)", path, err)
+		}
+	}
+	w.Header().Set("ETag", etag(info.Generation))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// delete checks If-Match before deleting the key, but both aren't atomic: a write made in between
+// is deleted too
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, path string) error {
+	generation, conditional, err := s.precondition(r, path)
+	if err != nil {
+		return err
+	}
+	info, err := s.info(r, path)
+	switch {
+	case errors.Is(err, auth.ErrPermissionDenied) && !conditional:
+		// Write-only principals can delete keys, but don't learn whether they existed
+	case err != nil:
+		return err
+	case conditional && generation != info.Generation:
+		return errorf(http.StatusPreconditionFailed, "key %s was modified", path)
+	}
+	err = s.kv.DeleteContext(r.Context(), path)
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		return err
+	}
+	if err != nil {
+		return errorf(http.StatusInternalServerError, "failed to delete key %s (%s)", path, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// precondition returns the generation a write is conditional on, if any: the one in If-Match, the
+// current one for "If-Match: *" or 0 for "If-None-Match: *"
+func (s *Server) precondition(r *http.Request, path string) (int64, bool, error) {
+	if r.Header.Get("If-None-Match") == "*" {
+		return 0, true, nil
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, false, nil
+	}
+	if ifMatch == "*" {
+		info, err := s.info(r, path)
+		if err != nil {
+			var httpErr *httpError
+			if errors.As(err, &httpErr) && httpErr.status == http.StatusNotFound {
+				return 0, false, errorf(http.StatusPreconditionFailed, "key %s not found", path)
+			}
+			return 0, false, err
+		}
+		return info.Generation, true, nil
+	}
+	generation, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+	if err != nil || generation < 1 {
+		return 0, false, errorf(http.StatusBadRequest, "invalid If-Match header %s", ifMatch)
+	}
+	return generation, true, nil
+}
+
+// info returns the info of the key at path, or a 404 error if there is no such key
+func (s *Server) info(r *http.Request, path string) (multikv.Info, error) {
+	info, err := s.kv.GetInfoContext(r.Context(), path)
+	if err == nil || errors.Is(err, auth.ErrPermissionDenied) {
+		return info, err
+	}
+	found, existErr := s.kv.ExistContext(r.Context(), path)
+	if existErr == nil && !found {
+		return info, errorf(http.StatusNotFound, "key %s not found", path)
+	}
+	return info, errorf(http.StatusInternalServerError, "failed to read info of key %s (%s)", path, err)
+}
+
+type listResponse struct {
+	Entries []listEntry `json:"entries"`
+	// Next is the cursor of the next page, only set for paginated requests
+	Next string `json:"next,omitempty"`
+}
+
+type listEntry struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// handleList returns every entry of the prefix, or one page of them with the limit (and cursor)
+// query parameters
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	err := s.list(w, r)
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+	prefix, err := s.requestPath(r, "/list/")
+	if err != nil {
+		return err
+	}
+	var entries []multikv.Entry
+	response := listResponse{Entries: []listEntry{}}
+	query := r.URL.Query()
+	if limit := query.Get("limit"); limit != "" {
+		pageSize, convErr := strconv.Atoi(limit)
+		if convErr != nil || pageSize < 1 {
+			return errorf(http.StatusBadRequest, "invalid limit %s", limit)
+		}
+		entries, response.Next, err = s.kv.ListPageContext(r.Context(), prefix, pageSize, query.Get("cursor"))
+	} else {
+		entries, err = s.kv.ListContext(r.Context(), prefix, multikv.ListOptions{})
+	}
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		return err
+	}
+	if err != nil {
+		// Only checked on failure, so that principals who can't list the prefix can't probe for keys
+		isKey, existErr := s.kv.ExistContext(r.Context(), prefix)
+		if existErr == nil && isKey {
+			return errorf(http.StatusBadRequest, "%s is a key, not a prefix", prefix)
+		}
+		return errorf(http.StatusInternalServerError, "failed to list %s (%s)", prefix, err)
+	}
+	for _, entry := range entries {
+		kind := "key"
+		if entry.Kind == multikv.PrefixEntry {
+			kind = "prefix"
+		}
+		response.Entries = append(response.Entries, listEntry{Name: entry.Name, Kind: kind})
+	}
+	writeJSON(w, http.StatusOK, response)
+	return nil
+}
+
+// requestPath returns the canonical key in the path of the request after route
+func (s *Server) requestPath(r *http.Request, route string) (string, error) {
+	key, err := s.kv.ParsePrefix(strings.TrimPrefix(r.URL.Path, route))
+	if err != nil {
+		return "", errorf(http.StatusBadRequest, "%s", err)
+	}
+	return string(key), nil
+}
+
+func etag(generation int64) string {
+	return `"` + strconv.FormatInt(generation, 10) + `"`
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/marcelocarlos/multikv"
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *multikv.KV) {
+	dir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	backend, err := local.NewLocalBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := &multikv.KV{Backend: backend}
+	ts := httptest.NewServer(NewServer(kv))
+	t.Cleanup(ts.Close)
+	return ts, kv
+}
+
+func do(t *testing.T, method string, url string, body string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func TestKeys(t *testing.T) {
+	ts, _ := newTestServer(t)
+	resp, _ := do(t, http.MethodPut, ts.URL+"/keys/test/key", "hello world", nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("ETag") != `"1"` {
+		t.Errorf("PUT: expected 204 with ETag \"1\", got %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	resp, body := do(t, http.MethodGet, ts.URL+"/keys/test/key", "", nil)
+	if resp.StatusCode != http.StatusOK || body != "hello world" || resp.Header.Get("ETag") != `"1"` {
+		t.Errorf("GET: expected 200 'hello world', got %d '%s'", resp.StatusCode, body)
+	}
+	resp, body = do(t, http.MethodGet, ts.URL+"/keys/test/key", "", map[string]string{"Range": "bytes=6-"})
+	if resp.StatusCode != http.StatusPartialContent || body != "world" {
+		t.Errorf("GET: expected 206 'world' for a range, got %d '%s'", resp.StatusCode, body)
+	}
+	resp, body = do(t, http.MethodGet, ts.URL+"/keys/test/key?info", "", nil)
+	info := multikv.Info{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil || resp.StatusCode != http.StatusOK || info.Path != "test/key" {
+		t.Errorf("GET: expected the info of test/key, got %d '%s'", resp.StatusCode, body)
+	}
+
+	resp, _ = do(t, http.MethodDelete, ts.URL+"/keys/test/key", "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE: expected 204, got %d", resp.StatusCode)
+	}
+	resp, body = do(t, http.MethodGet, ts.URL+"/keys/test/key", "", nil)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, `"error"`) {
+		t.Errorf("GET: expected a 404 JSON error, got %d '%s'", resp.StatusCode, body)
+	}
+}
+
+func TestKeys_Conditional(t *testing.T) {
+	ts, _ := newTestServer(t)
+	url := ts.URL + "/keys/test/key"
+	resp, _ := do(t, http.MethodPut, url, "v1", map[string]string{"If-None-Match": "*"})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT: creating the key should have succeeded, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodPut, url, "v2", map[string]string{"If-None-Match": "*"})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT: creating an existing key should have failed, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodPut, url, "v2", map[string]string{"If-Match": `"1"`})
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("ETag") != `"2"` {
+		t.Errorf("PUT: updating generation 1 should have succeeded, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodPut, url, "v3", map[string]string{"If-Match": `"1"`})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT: updating a stale generation should have failed, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodDelete, url, "", map[string]string{"If-Match": `"1"`})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE: deleting a stale generation should have failed, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodGet, url, "", map[string]string{"If-None-Match": `"2"`})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET: expected 304 for the current generation, got %d", resp.StatusCode)
+	}
+}
+
+func TestList(t *testing.T) {
+	ts, kv := newTestServer(t)
+	for _, path := range []string{"test/a", "test/b", "test/sub/c"} {
+		err := kv.Put(path, []byte("test"))
+		if err != nil {
+			t.Fatalf("List: failed to prepare (%s)", err)
+		}
+	}
+	resp, body := do(t, http.MethodGet, ts.URL+"/list/test", "", nil)
+	expected := `{"entries":[{"name":"a","kind":"key"},{"name":"b","kind":"key"},{"name":"sub","kind":"prefix"}]}` + "\n"
+	if resp.StatusCode != http.StatusOK || body != expected {
+		t.Errorf("List: expected %s, got %d %s", expected, resp.StatusCode, body)
+	}
+	resp, body = do(t, http.MethodGet, ts.URL+"/list/test?limit=2", "", nil)
+	expected = `{"entries":[{"name":"a","kind":"key"},{"name":"b","kind":"key"}],"next":"b/"}` + "\n"
+	if resp.StatusCode != http.StatusOK || body != expected {
+		t.Errorf("List: expected %s, got %d %s", expected, resp.StatusCode, body)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/list/test/a", "", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("List: listing a key should have failed, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend, err := local.NewLocalBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := &multikv.KV{Backend: backend, Authorizer: &auth.Authorizer{Policy: &auth.Policy{Rules: []auth.Rule{
+		{Principals: []string{"alice"}, Prefix: "", Permissions: []auth.Permission{auth.Read, auth.Write, auth.List}},
+		{Principals: []string{"bob"}, Prefix: "public", Permissions: []auth.Permission{auth.Read, auth.List}},
+		{Principals: []string{"carol"}, Prefix: "inbox", Permissions: []auth.Permission{auth.Write}},
+	}}}}
+	ts := httptest.NewServer(NewServer(kv, WithAuthenticator(auth.StaticTokens{"alice": "alice-token", "bob": "bob-token", "carol": "carol-token"})))
+	defer ts.Close()
+	alice := map[string]string{"Authorization": "Bearer alice-token"}
+	bob := map[string]string{"Authorization": "Bearer bob-token"}
+
+	resp, _ := do(t, http.MethodGet, ts.URL+"/keys/public/a", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("GET: expected 401 without a token, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/keys/public/a", "", map[string]string{"Authorization": "Bearer wrong"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET: expected 401 with an invalid token, got %d", resp.StatusCode)
+	}
+	for _, path := range []string{"public/a", "secrets/db"} {
+		resp, _ = do(t, http.MethodPut, ts.URL+"/keys/"+path, "test", alice)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("PUT: alice should be able to write %s, got %d", path, resp.StatusCode)
+		}
+	}
+	resp, body := do(t, http.MethodGet, ts.URL+"/keys/public/a", "", bob)
+	if resp.StatusCode != http.StatusOK || body != "test" {
+		t.Errorf("GET: bob should be able to read public keys, got %d '%s'", resp.StatusCode, body)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/keys/secrets/db", "", bob)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET: bob should not be able to read secrets, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/keys/secrets/missing", "", bob)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET: bob should not learn which secrets exist, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodPut, ts.URL+"/keys/public/a", "test", bob)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PUT: bob should not be able to write, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodDelete, ts.URL+"/keys/public/a", "", bob)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE: bob should not be able to delete, got %d", resp.StatusCode)
+	}
+	carol := map[string]string{"Authorization": "Bearer carol-token"}
+	resp, _ = do(t, http.MethodPut, ts.URL+"/keys/inbox/a", "test", carol)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT: carol should be able to write to the inbox, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodDelete, ts.URL+"/keys/inbox/a", "", map[string]string{"Authorization": "Bearer carol-token", "If-Match": `"1"`})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE: carol should not be able to check If-Match without reading, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodDelete, ts.URL+"/keys/inbox/a", "", carol)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE: carol should be able to delete from the inbox, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/keys/inbox/a", "", alice)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE: expected inbox/a to be deleted, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/list/secrets", "", bob)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("List: bob should not be able to list secrets, got %d", resp.StatusCode)
+	}
+	resp, _ = do(t, http.MethodGet, ts.URL+"/list/public?limit=1", "", bob)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("List: bob should be able to list public keys, got %d", resp.StatusCode)
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	ts, _ := newTestServer(t)
+	for _, path := range []string{"/keys/test/data", "/keys/test/a%20b", "/list/test/info"} {
+		resp, body := do(t, http.MethodGet, ts.URL+path, "", nil)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "invalid key") {
+			t.Errorf("GET %s: expected 400 for an invalid key, got %d %s", path, resp.StatusCode, body)
+		}
+	}
+}
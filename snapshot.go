@@ -0,0 +1,267 @@
+package multikv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+// snapshotsDir holds the snapshots of the store or of a Sub, next to its keys. It is skipped when
+// walking the store.
+const snapshotsDir = ".snapshots"
+
+type Snapshot struct {
+	Name      string    `yaml:"name"`
+	Prefix    string    `yaml:"prefix"`
+	CreatedAt time.Time `yaml:"createdAt"`
+	Keys      []string  `yaml:"keys"`
+}
+
+// Snapshot freezes the current state of every key under prefix. Files are copied with the backend's
+// cheap copy when available (server-side copy in GCS, hardlinks in the local backend).
+func (kv *KV) Snapshot(prefix string, name string) (snapshot Snapshot, err error) {
+	backend, op := kv.begin(context.Background(), "Snapshot", prefix)
+	defer func() { op.end(0, err) }()
+	snapshot = Snapshot{Name: name, Prefix: prefix, CreatedAt: time.Now()}
+	err = validateSnapshotName(name)
+	if err != nil {
+		return snapshot, err
+	}
+	prefix, err = kv.prefixPath(prefix)
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Prefix = prefix
+	err = kv.authorize(context.Background(), auth.Read, "Snapshot", prefix)
+	if err != nil {
+		return snapshot, err
+	}
+	err = kv.authorize(context.Background(), auth.Write, "Snapshot", snapshotsDir)
+	if err != nil {
+		return snapshot, err
+	}
+	found, err := backend.Exist(kv.snapshotManifest(name))
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to check snapshot %s (%s)", name, err)
+	}
+	if found {
+		return snapshot, fmt.Errorf("snapshot %s already exists", name)
+	}
+	// Leftovers from a snapshot that failed halfway
+	err = backend.DeleteDir(kv.snapshotPath(name))
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to prepare snapshot %s (%s)", name, err)
+	}
+
+	err = kv.walk(backend, prefix, func(key string) error {
+		for _, file := range []string{"data", "info"} {
+			err := kv.copyFile(backend, kv.keyFile(key, file), joinKey(kv.snapshotKeysPath(name), kv.encodedKey(key), file))
+			if err != nil {
+				return fmt.Errorf("failed to copy %s (%s)", key, err)
+			}
+		}
+		snapshot.Keys = append(snapshot.Keys, key)
+		return nil
+	})
+	if err != nil {
+		return snapshot, err
+	}
+	// The manifest is written last, snapshots without one are incomplete and ignored
+	manifest, err := json.Marshal(&snapshot)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to generate snapshot manifest (%s)", err)
+	}
+	err = backend.WriteFile(kv.snapshotManifest(name), manifest)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to write snapshot manifest (%s)", err)
+	}
+	return snapshot, nil
+}
+
+func (kv *KV) GetSnapshot(name string) (snapshot Snapshot, err error) {
+	backend, op := kv.begin(context.Background(), "GetSnapshot", "")
+	defer func() { op.end(0, err) }()
+	err = validateSnapshotName(name)
+	if err != nil {
+		return snapshot, err
+	}
+	err = kv.authorize(context.Background(), auth.Read, "GetSnapshot", snapshotsDir)
+	if err != nil {
+		return snapshot, err
+	}
+	return kv.getSnapshot(backend, name)
+}
+
+func (kv *KV) getSnapshot(backend backends.KvBackend, name string) (Snapshot, error) {
+	snapshot := Snapshot{}
+	manifest, err := backend.ReadFile(kv.snapshotManifest(name))
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read snapshot %s (%s)", name, err)
+	}
+	err = json.Unmarshal(manifest, &snapshot)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to parse snapshot manifest (%s)", err)
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the complete snapshots of the store, oldest first
+func (kv *KV) ListSnapshots() (_ []Snapshot, err error) {
+	backend, op := kv.begin(context.Background(), "ListSnapshots", "")
+	defer func() { op.end(0, err) }()
+	err = kv.authorize(context.Background(), auth.List, "ListSnapshots", snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	dirList, err := backend.ListDir(joinKey(kv.root, snapshotsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots (%s)", err)
+	}
+	var snapshots []Snapshot
+	for _, f := range dirList {
+		if !strings.HasSuffix(f, "/") {
+			continue
+		}
+		name := strings.TrimSuffix(f, "/")
+		found, err := backend.Exist(kv.snapshotManifest(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check snapshot %s (%s)", name, err)
+		}
+		if !found {
+			continue
+		}
+		snapshot, err := kv.getSnapshot(backend, name)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// RestoreSnapshot brings the snapshot's prefix back to the state it was in when the snapshot was
+// taken: keys created since then are deleted and the others get their old value and CreatedAt back,
+// as a new generation. Both are audited, as Deletes and Puts.
+func (kv *KV) RestoreSnapshot(name string) (err error) {
+	backend, op := kv.begin(context.Background(), "RestoreSnapshot", "")
+	defer func() { op.end(0, err) }()
+	err = validateSnapshotName(name)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(context.Background(), auth.Read, "RestoreSnapshot", snapshotsDir)
+	if err != nil {
+		return err
+	}
+	snapshot, err := kv.getSnapshot(backend, name)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(context.Background(), auth.Write, "RestoreSnapshot", snapshot.Prefix)
+	if err != nil {
+		return err
+	}
+	inSnapshot := make(map[string]bool, len(snapshot.Keys))
+	for _, key := range snapshot.Keys {
+		inSnapshot[key] = true
+	}
+	var created []string
+	err = kv.walk(backend, snapshot.Prefix, func(key string) error {
+		if !inSnapshot[key] {
+			created = append(created, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Only the keys' own files are removed, keys nested under them may be in the snapshot
+	err = kv.deleteKeyFiles(backend, created)
+	if err != nil {
+		return fmt.Errorf("failed to delete keys created after the snapshot (%s)", err)
+	}
+	for _, key := range snapshot.Keys {
+		stored := joinKey(kv.snapshotKeysPath(name), kv.encodedKey(key))
+		oldChecksum, err := kv.auditChecksum(backend, key)
+		if err != nil {
+			return err
+		}
+		newChecksum := ""
+		if kv.Audit != nil {
+			newChecksum, err = storedChecksum(backend, stored)
+			if err != nil {
+				return fmt.Errorf("failed to read %s for audit (%s)", key, err)
+			}
+		}
+		// The value and CreatedAt come back, but the restore is a new generation of the key
+		info, err := getInfo(backend, stored)
+		if err != nil {
+			return fmt.Errorf("failed to read info file of %s in the snapshot (%s)", key, err)
+		}
+		info.Path = key
+		info.UpdatedAt = time.Now()
+		info.Generation = 1
+		info, err = nextGeneration(backend, kv.storedPath(key), info)
+		if err != nil {
+			return fmt.Errorf("failed to read info file of %s (%s)", key, err)
+		}
+		// data first, like Put
+		err = kv.copyFile(backend, joinKey(stored, "data"), kv.keyFile(key, "data"))
+		if err != nil {
+			return fmt.Errorf("failed to restore %s (%s)", key, err)
+		}
+		infoJSON, err := json.Marshal(&info)
+		if err != nil {
+			return fmt.Errorf("failed to generate info file (%s)", err)
+		}
+		err = backend.WriteFile(kv.keyFile(key, "info"), infoJSON)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s (%s)", key, err)
+		}
+		err = kv.audit(context.Background(), AuditRecord{Operation: "Put", Path: key, OldChecksum: oldChecksum, NewChecksum: newChecksum})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (kv *KV) DeleteSnapshot(name string) (err error) {
+	backend, op := kv.begin(context.Background(), "DeleteSnapshot", "")
+	defer func() { op.end(0, err) }()
+	err = validateSnapshotName(name)
+	if err != nil {
+		return err
+	}
+	err = kv.authorize(context.Background(), auth.Write, "DeleteSnapshot", snapshotsDir)
+	if err != nil {
+		return err
+	}
+	return backend.DeleteDir(kv.snapshotPath(name))
+}
+
+func validateSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid snapshot name '%s'", name)
+	}
+	return nil
+}
+
+func (kv *KV) snapshotPath(name string) string {
+	return joinKey(kv.root, snapshotsDir, name)
+}
+
+func (kv *KV) snapshotManifest(name string) string {
+	return joinKey(kv.snapshotPath(name), "manifest")
+}
+
+func (kv *KV) snapshotKeysPath(name string) string {
+	return joinKey(kv.snapshotPath(name), "keys")
+}
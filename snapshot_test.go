@@ -0,0 +1,125 @@
+package multikv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	for _, path := range []string{"test/a", "test/b", "other"} {
+		err := kv.Put(path, []byte("v1"))
+		if err != nil {
+			t.Fatalf("TestSnapshot: Put should have succeeded (%s)", err)
+		}
+	}
+	original, err := kv.GetInfo("test/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := kv.Snapshot("test", "before-rollout")
+	if err != nil {
+		t.Fatalf("TestSnapshot: Snapshot should not have failed (%s)", err)
+	}
+	expected := []string{"test/a", "test/b"}
+	if !reflect.DeepEqual(snapshot.Keys, expected) {
+		t.Errorf("TestSnapshot: snapshot keys did not match. Expected: %v; Found: %v", expected, snapshot.Keys)
+	}
+	_, err = kv.Snapshot("test", "before-rollout")
+	if err == nil {
+		t.Errorf("TestSnapshot: creating a snapshot with an existing name should have failed")
+	}
+
+	// Changes made after the snapshot must not leak into it
+	err = kv.Put("test/a", []byte("v2"))
+	if err != nil {
+		t.Fatalf("TestSnapshot: Put should have succeeded (%s)", err)
+	}
+	err = kv.Put("test/c", []byte("v2"))
+	if err != nil {
+		t.Fatalf("TestSnapshot: Put should have succeeded (%s)", err)
+	}
+	err = kv.Delete("test/b")
+	if err != nil {
+		t.Fatalf("TestSnapshot: Delete should have succeeded (%s)", err)
+	}
+
+	err = kv.RestoreSnapshot("before-rollout")
+	if err != nil {
+		t.Fatalf("TestSnapshot: RestoreSnapshot should not have failed (%s)", err)
+	}
+	for _, path := range []string{"test/a", "test/b"} {
+		data, err := kv.Get(path)
+		if err != nil || string(data) != "v1" {
+			t.Errorf("TestSnapshot: %s should have been restored to 'v1' (got '%s', %v)", path, data, err)
+		}
+	}
+	// Restoring is a new write of the key, generations never go back
+	info, err := kv.GetInfo("test/a")
+	if err != nil || info.Generation != 3 || !info.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("TestSnapshot: expected generation 3 with the original CreatedAt (got %+v, %v)", info, err)
+	}
+	info, err = kv.GetInfo("test/b")
+	if err != nil || info.Generation != 1 {
+		t.Errorf("TestSnapshot: expected a deleted key to be restored at generation 1 (got %+v, %v)", info, err)
+	}
+	_, err = kv.PutIf("test/a", []byte("v3"), 2)
+	if err != ErrGenerationMismatch {
+		t.Errorf("TestSnapshot: a write based on the generation before the restore should fail, got %v", err)
+	}
+	_, err = kv.Get("test/c")
+	if err == nil {
+		t.Errorf("TestSnapshot: key created after the snapshot should have been removed")
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	snapshots, err := kv.ListSnapshots()
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("TestListSnapshots: expected no snapshots (got %v, %v)", snapshots, err)
+	}
+	err = kv.Put("key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestListSnapshots: Put should have succeeded (%s)", err)
+	}
+	for _, name := range []string{"first", "second"} {
+		_, err = kv.Snapshot("", name)
+		if err != nil {
+			t.Fatalf("TestListSnapshots: Snapshot should not have failed (%s)", err)
+		}
+	}
+	snapshots, err = kv.ListSnapshots()
+	if err != nil {
+		t.Fatalf("TestListSnapshots: ListSnapshots should not have failed (%s)", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "first" || snapshots[1].Name != "second" {
+		t.Errorf("TestListSnapshots: expected snapshots first and second, got %+v", snapshots)
+	}
+	// Snapshots of the whole store must not include older snapshots
+	if !reflect.DeepEqual(snapshots[1].Keys, []string{"key"}) {
+		t.Errorf("TestListSnapshots: expected snapshot keys [key], got %v", snapshots[1].Keys)
+	}
+
+	err = kv.DeleteSnapshot("first")
+	if err != nil {
+		t.Fatalf("TestListSnapshots: DeleteSnapshot should not have failed (%s)", err)
+	}
+	snapshots, err = kv.ListSnapshots()
+	if err != nil || len(snapshots) != 1 {
+		t.Errorf("TestListSnapshots: expected one snapshot left (got %v, %v)", snapshots, err)
+	}
+}
+
+func TestSnapshot_InvalidName(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	for _, name := range []string{"", "..", "a/b"} {
+		_, err := kv.Snapshot("", name)
+		if err == nil {
+			t.Errorf("TestSnapshot_InvalidName: should have failed for '%s'", name)
+		}
+	}
+}
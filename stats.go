@@ -0,0 +1,179 @@
+package multikv
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+	"github.com/marcelocarlos/multikv/backends/middleware"
+)
+
+const (
+	ErrorNotFound           = "not_found"
+	ErrorPreconditionFailed = "precondition_failed"
+	ErrorCanceled           = "canceled"
+	ErrorPermissionDenied   = "permission_denied"
+	ErrorOther              = "other"
+)
+
+// Metrics records statistics about the operations of the KV it is set on, which are read with
+// KV.Stats. It is safe to share between several KVs, whose statistics are then added up.
+type Metrics struct {
+	buckets []float64
+	mu      sync.Mutex
+	stats   Stats
+}
+
+// Stats are the statistics recorded since a Metrics was created
+type Stats struct {
+	// Operations are keyed by operation name: Put, Get, GetInfo, Delete, List, ListPage, Copy...
+	Operations   map[string]OperationStats
+	BytesRead    uint64
+	BytesWritten uint64
+	// Errors are keyed by kind: ErrorNotFound, ErrorPreconditionFailed, ErrorCanceled,
+	// ErrorPermissionDenied or ErrorOther
+	Errors map[string]uint64
+}
+
+type OperationStats struct {
+	Count   uint64
+	Errors  uint64
+	Latency Histogram
+}
+
+type Histogram = middleware.Histogram
+
+// NewMetrics measures latencies with middleware.DefaultBuckets, like the backend metrics
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(middleware.DefaultBuckets)
+}
+
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	return &Metrics{
+		buckets: buckets,
+		stats: Stats{
+			Operations: make(map[string]OperationStats),
+			Errors:     make(map[string]uint64),
+		},
+	}
+}
+
+// Stats returns the statistics recorded by kv.Metrics, or empty statistics if it isn't set
+func (kv *KV) Stats() Stats {
+	if kv.Metrics == nil {
+		return Stats{Operations: make(map[string]OperationStats), Errors: make(map[string]uint64)}
+	}
+	return kv.Metrics.Stats()
+}
+
+func (m *Metrics) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Operations = make(map[string]OperationStats, len(m.stats.Operations))
+	for name, op := range m.stats.Operations {
+		op.Latency = op.Latency.Copy()
+		stats.Operations[name] = op
+	}
+	stats.Errors = make(map[string]uint64, len(m.stats.Errors))
+	for kind, n := range m.stats.Errors {
+		stats.Errors[kind] = n
+	}
+	return stats
+}
+
+func (m *Metrics) record(name string, elapsed time.Duration, size int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, ok := m.stats.Operations[name]
+	if !ok {
+		op.Latency = middleware.NewHistogram(m.buckets)
+	}
+	op.Count++
+	op.Latency.Observe(elapsed.Seconds())
+	switch {
+	case err != nil:
+		op.Errors++
+		m.stats.Errors[errorKind(err)]++
+	case name == "Get" || name == "GetReader":
+		m.stats.BytesRead += uint64(size)
+	case name == "Put" || name == "PutIf" || name == "PutReader":
+		m.stats.BytesWritten += uint64(size)
+	}
+	m.stats.Operations[name] = op
+}
+
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrKeyNotFound) || errors.Is(err, os.ErrNotExist):
+		return ErrorNotFound
+	case errors.Is(err, backends.ErrPreconditionFailed) || errors.Is(err, ErrGenerationMismatch):
+		return ErrorPreconditionFailed
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return ErrorCanceled
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return ErrorPermissionDenied
+	}
+	return ErrorOther
+}
+
+// WritePrometheus writes the statistics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	stats := m.Stats()
+	var names []string
+	for name := range stats.Operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var kinds []string
+	for kind := range stats.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	pw := middleware.NewPrometheusWriter(w)
+	pw.Header("multikv_operations_total", "counter", "Number of KV operations.")
+	for _, name := range names {
+		pw.Sample("multikv_operations_total", middleware.Labels("op", name), stats.Operations[name].Count)
+	}
+	pw.Header("multikv_operation_errors_total", "counter", "Number of KV operations that failed.")
+	for _, name := range names {
+		pw.Sample("multikv_operation_errors_total", middleware.Labels("op", name), stats.Operations[name].Errors)
+	}
+	pw.Header("multikv_errors_total", "counter", "Number of KV errors by kind.")
+	for _, kind := range kinds {
+		pw.Sample("multikv_errors_total", middleware.Labels("kind", kind), stats.Errors[kind])
+	}
+	pw.Header("multikv_read_bytes_total", "counter", "Number of bytes read by Get and GetReader.")
+	pw.Sample("multikv_read_bytes_total", "", stats.BytesRead)
+	pw.Header("multikv_written_bytes_total", "counter", "Number of bytes written by Put, PutIf and PutReader.")
+	pw.Sample("multikv_written_bytes_total", "", stats.BytesWritten)
+	pw.Header("multikv_operation_duration_seconds", "histogram", "Latency of KV operations.")
+	for _, name := range names {
+		op := stats.Operations[name]
+		pw.Histogram("multikv_operation_duration_seconds", middleware.Labels("op", name), op.Latency, op.Count)
+	}
+	return pw.Err()
+}
+
+// ServeHTTP serves the statistics in the Prometheus text format, to be scraped by Prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.WritePrometheus(w)
+}
+
+// PublishExpvar publishes the statistics as an expvar variable, which is served as JSON on
+// /debug/vars. Like expvar.Publish, it panics if name is already used.
+func (m *Metrics) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Stats()
+	}))
+}
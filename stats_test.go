@@ -0,0 +1,120 @@
+package multikv
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/backends/middleware"
+)
+
+func TestStats(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	kv.Metrics = NewMetricsWithBuckets([]float64{60})
+	err := kv.Put("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestStats: Put should have succeeded (%s)", err)
+	}
+	_, err = kv.Get("test/key")
+	if err != nil {
+		t.Fatalf("TestStats: Get should have succeeded (%s)", err)
+	}
+	_, err = kv.Get("test/missing")
+	if err == nil {
+		t.Fatalf("TestStats: Get should have failed")
+	}
+
+	stats := kv.Stats()
+	if stats.Operations["Put"].Count != 1 || stats.Operations["Get"].Count != 2 || stats.Operations["Get"].Errors != 1 {
+		t.Errorf("TestStats: unexpected operation counts %+v", stats.Operations)
+	}
+	if stats.BytesRead != 4 || stats.BytesWritten != 4 {
+		t.Errorf("TestStats: expected 4 bytes read and written, got %d and %d", stats.BytesRead, stats.BytesWritten)
+	}
+	if stats.Errors[ErrorNotFound] != 1 {
+		t.Errorf("TestStats: expected a not found error, got %v", stats.Errors)
+	}
+	if latency := stats.Operations["Get"].Latency; len(latency.Counts) != 1 || latency.Counts[0] != 2 {
+		t.Errorf("TestStats: expected both gets in the latency bucket, got %+v", latency)
+	}
+
+	var buf bytes.Buffer
+	err = kv.Metrics.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatalf("TestStats: WritePrometheus should not have failed (%s)", err)
+	}
+	for _, line := range []string{
+		`multikv_operations_total{op="Get"} 2`,
+		`multikv_errors_total{kind="not_found"} 1`,
+		`multikv_read_bytes_total 4`,
+		`multikv_operation_duration_seconds_bucket{op="Put",le="60"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("TestStats: expected line %q in:\n%s", line, buf.String())
+		}
+	}
+
+	kv.Metrics.PublishExpvar("multikv_test")
+	published := Stats{}
+	err = json.Unmarshal([]byte(expvar.Get("multikv_test").String()), &published)
+	if err != nil || published.Operations["Put"].Count != 1 {
+		t.Errorf("TestStats: unexpected expvar value %s (%v)", expvar.Get("multikv_test"), err)
+	}
+}
+
+func TestStats_Disabled(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestStats_Disabled: Put should have succeeded (%s)", err)
+	}
+	if stats := kv.Stats(); len(stats.Operations) != 0 {
+		t.Errorf("TestStats_Disabled: expected no statistics without Metrics, got %+v", stats)
+	}
+}
+
+func TestStats_AllOperations(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	kv.Metrics = NewMetrics()
+	_, err := kv.PutIf("test/key", []byte("ab"), 0)
+	if err != nil {
+		t.Fatalf("TestStats_AllOperations: PutIf should have succeeded (%s)", err)
+	}
+	_, err = kv.PutIf("test/key", []byte("ab"), 0)
+	if err != ErrGenerationMismatch {
+		t.Fatalf("TestStats_AllOperations: expected ErrGenerationMismatch, got %v", err)
+	}
+	err = kv.PutReader("test/stream", strings.NewReader("abc"))
+	if err != nil {
+		t.Fatalf("TestStats_AllOperations: PutReader should have succeeded (%s)", err)
+	}
+	err = kv.Copy("test/key", "test/copy")
+	if err != nil {
+		t.Fatalf("TestStats_AllOperations: Copy should have succeeded (%s)", err)
+	}
+	_, _, err = kv.ListPage("test", 1, "")
+	if err != nil {
+		t.Fatalf("TestStats_AllOperations: ListPage should have succeeded (%s)", err)
+	}
+
+	stats := kv.Stats()
+	for name, count := range map[string]uint64{"PutIf": 2, "PutReader": 1, "Copy": 1, "ListPage": 1} {
+		if stats.Operations[name].Count != count {
+			t.Errorf("TestStats_AllOperations: expected %d %s, got %+v", count, name, stats.Operations[name])
+		}
+	}
+	if stats.BytesWritten != 5 {
+		t.Errorf("TestStats_AllOperations: expected 5 bytes written, got %d", stats.BytesWritten)
+	}
+	if stats.Errors[ErrorPreconditionFailed] != 1 {
+		t.Errorf("TestStats_AllOperations: expected a precondition error, got %v", stats.Errors)
+	}
+	if latency := stats.Operations["Copy"].Latency; len(latency.Buckets) != len(middleware.DefaultBuckets) {
+		t.Errorf("TestStats_AllOperations: expected the default buckets of the backend metrics, got %v", latency.Buckets)
+	}
+}
@@ -0,0 +1,23 @@
+package multikv
+
+// Sub returns a view of the keys under prefix, whose operations take keys relative to prefix and
+// can't reach keys outside of it. The view shares the backend and the settings of kv, and has its own
+// snapshots. Authorization and audit records still use keys from the root of the store, so that a
+// policy for prefix applies to the view. If prefix isn't valid, every operation of the view fails with
+// an error wrapping ErrInvalidKey.
+func (kv *KV) Sub(prefix string) *KV {
+	sub := *kv
+	key, err := kv.ParsePrefix(prefix)
+	if err != nil {
+		sub.err = err
+		return &sub
+	}
+	sub.root = kv.storedPath(string(key))
+	sub.prefix = joinKey(kv.prefix, string(key))
+	return &sub
+}
+
+// Prefix returns the prefix of kv from the root of the store, empty unless kv is a Sub
+func (kv *KV) Prefix() string {
+	return kv.prefix
+}
@@ -0,0 +1,150 @@
+package multikv
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/marcelocarlos/multikv/auth"
+)
+
+func TestSub(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	a := kv.Sub("tenants/a")
+	b := kv.Sub("tenants/b")
+	for _, sub := range []*KV{a, b} {
+		err := sub.Put("config/name", []byte(sub.Prefix()))
+		if err != nil {
+			t.Fatalf("TestSub: Put should have succeeded (%s)", err)
+		}
+	}
+
+	value, err := kv.Get("tenants/a/config/name")
+	if err != nil || string(value) != "tenants/a" {
+		t.Errorf("TestSub: expected the key to be stored under the prefix, got %q (%v)", value, err)
+	}
+	value, err = b.Get("config/name")
+	if err != nil || string(value) != "tenants/b" {
+		t.Errorf("TestSub: expected the value of b, got %q (%v)", value, err)
+	}
+	entries, err := a.List("")
+	if err != nil || !reflect.DeepEqual(entries, []Entry{{Name: "config", Kind: PrefixEntry}}) {
+		t.Errorf("TestSub: unexpected entries %+v (%v)", entries, err)
+	}
+	var keys []string
+	err = a.Walk("", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(keys, []string{"config/name"}) {
+		t.Errorf("TestSub: expected to walk config/name, got %v (%v)", keys, err)
+	}
+
+	// Nested views add up
+	value, err = kv.Sub("tenants").Sub("a").Get("config/name")
+	if err != nil || string(value) != "tenants/a" {
+		t.Errorf("TestSub: expected the value of a through nested views, got %q (%v)", value, err)
+	}
+
+	err = a.Delete("config")
+	if err != nil {
+		t.Errorf("TestSub: Delete should have succeeded (%s)", err)
+	}
+	found, err := kv.Exist("tenants/b/config/name")
+	if err != nil || !found {
+		t.Errorf("TestSub: deleting from a should not affect b (%v)", err)
+	}
+}
+
+func TestSub_Escape(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	err := kv.Put("secret", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := kv.Sub("tenants/a")
+	for _, path := range []string{"../../secret", "/../secret", "x/../../../secret"} {
+		_, err = sub.Get(path)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("TestSub_Escape: %s: expected an invalid key error, got %v", path, err)
+		}
+	}
+	invalid := kv.Sub("tenants/../secret")
+	err = invalid.Put("a", []byte("test"))
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("TestSub_Escape: expected an invalid prefix to fail every operation, got %v", err)
+	}
+	_, err = invalid.List("")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("TestSub_Escape: expected an invalid prefix to fail List, got %v", err)
+	}
+}
+
+func TestSub_Snapshots(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	a := kv.Sub("a")
+	err := a.Put("key", []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Snapshot("", "snap")
+	if err != nil {
+		t.Fatalf("TestSub_Snapshots: Snapshot should have succeeded (%s)", err)
+	}
+	snapshots, err := kv.Sub("b").ListSnapshots()
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("TestSub_Snapshots: expected no snapshots in another view, got %+v (%v)", snapshots, err)
+	}
+	entries, err := kv.List("a")
+	if err != nil || !reflect.DeepEqual(entries, []Entry{{Name: "key", Kind: KeyEntry}}) {
+		t.Errorf("TestSub_Snapshots: expected the snapshots to be hidden, got %+v (%v)", entries, err)
+	}
+	err = a.Put("key", []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.RestoreSnapshot("snap")
+	if err != nil {
+		t.Fatalf("TestSub_Snapshots: RestoreSnapshot should have succeeded (%s)", err)
+	}
+	value, err := a.Get("key")
+	if err != nil || string(value) != "v1" {
+		t.Errorf("TestSub_Snapshots: expected v1, got %q (%v)", value, err)
+	}
+}
+
+func TestSub_AuthorizationAndAudit(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	kv.Authorizer = &auth.Authorizer{Policy: &auth.Policy{Rules: []auth.Rule{
+		{Principals: []string{"alice"}, Prefix: "tenants/a", Permissions: []auth.Permission{auth.Read, auth.Write, auth.List}},
+	}}}
+	sink := &memoryAuditSink{}
+	kv.Audit = sink
+	alice := auth.WithPrincipal(context.Background(), "alice")
+
+	err := kv.Sub("tenants/a").PutContext(alice, "key", []byte("test"))
+	if err != nil {
+		t.Errorf("TestSub_AuthorizationAndAudit: alice should be able to write in her view (%s)", err)
+	}
+	err = kv.Sub("tenants/b").PutContext(alice, "key", []byte("test"))
+	if !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("TestSub_AuthorizationAndAudit: alice should not be able to write in another view, got %v", err)
+	}
+	if len(sink.records) != 1 || sink.records[0].Path != "tenants/a/key" {
+		t.Errorf("TestSub_AuthorizationAndAudit: expected a record of tenants/a/key, got %+v", sink.records)
+	}
+}
+
+type memoryAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *memoryAuditSink) Record(record AuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
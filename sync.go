@@ -0,0 +1,215 @@
+package multikv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/marcelocarlos/multikv/auth"
+	"github.com/marcelocarlos/multikv/backends"
+)
+
+type SyncOptions struct {
+	// Prefix restricts the sync to the keys stored under it. Empty means the whole store.
+	Prefix string
+	// Incremental skips keys that are already up to date in the destination, based on their UpdatedAt
+	Incremental bool
+	// Checksum makes incremental syncs compare the stored values instead of UpdatedAt
+	Checksum bool
+	// DryRun reports what would be done without changing the destination
+	DryRun bool
+	// Delete removes keys from the destination that don't exist in the source
+	Delete bool
+	// Concurrency is the number of keys copied in parallel. Defaults to 1.
+	Concurrency int
+}
+
+type SyncResult struct {
+	Copied  []string
+	Skipped []string
+	Deleted []string
+	Failed  map[string]error
+}
+
+// Sync copies every key from src to dst. Both the data and the info file are copied, so keys keep
+// their original CreatedAt and UpdatedAt. Keys new to dst also keep their generation, keys that
+// already exist there get the generation after their current one. The Audit sink of dst records a Put
+// for every key copied and a Delete for every key deleted.
+func Sync(src KV, dst KV, opts SyncOptions) (result SyncResult, err error) {
+	srcBackend, srcOp := src.begin(context.Background(), "Sync", opts.Prefix)
+	defer func() { srcOp.end(0, err) }()
+	dstBackend, dstOp := dst.begin(context.Background(), "Sync", opts.Prefix)
+	defer func() { dstOp.end(0, err) }()
+	result = SyncResult{Failed: make(map[string]error)}
+	prefix, err := src.prefixPath(opts.Prefix)
+	if err != nil {
+		return result, err
+	}
+	opts.Prefix = prefix
+	err = src.authorize(context.Background(), auth.Read, "Sync", opts.Prefix)
+	if err != nil {
+		return result, err
+	}
+	err = dst.authorize(context.Background(), auth.Write, "Sync", opts.Prefix)
+	if err != nil {
+		return result, err
+	}
+	var srcKeys []string
+	err = src.walk(srcBackend, opts.Prefix, func(key string) error {
+		srcKeys = append(srcKeys, key)
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to list source keys (%s)", err)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	keys := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				copied, err := syncKey(src, srcBackend, dst, dstBackend, key, opts)
+				mu.Lock()
+				switch {
+				case err != nil:
+					result.Failed[key] = err
+				case copied:
+					result.Copied = append(result.Copied, key)
+				default:
+					result.Skipped = append(result.Skipped, key)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, key := range srcKeys {
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+	sort.Strings(result.Copied)
+	sort.Strings(result.Skipped)
+
+	if opts.Delete {
+		inSrc := make(map[string]bool, len(srcKeys))
+		for _, key := range srcKeys {
+			inSrc[key] = true
+		}
+		err = dst.walk(dstBackend, opts.Prefix, func(key string) error {
+			if !inSrc[key] {
+				result.Deleted = append(result.Deleted, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to list destination keys (%s)", err)
+		}
+		if !opts.DryRun && len(result.Deleted) > 0 {
+			// Only the keys' own files are removed, keys nested under them may still exist in src
+			err = dst.deleteKeyFiles(dstBackend, result.Deleted)
+			if err != nil {
+				return result, fmt.Errorf("failed to delete keys from the destination (%s)", err)
+			}
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("failed to sync %d key(s)", len(result.Failed))
+	}
+	return result, nil
+}
+
+// syncKey copies a single key from src to dst, returning false if it was already up to date
+func syncKey(src KV, srcBackend backends.KvBackend, dst KV, dstBackend backends.KvBackend, key string, opts SyncOptions) (bool, error) {
+	srcInfoFile, err := srcBackend.ReadFile(src.keyFile(key, "info"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read info file (%s)", err)
+	}
+	srcData, err := srcBackend.ReadFile(src.keyFile(key, "data"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read data file (%s)", err)
+	}
+	if opts.Incremental {
+		upToDate, err := isUpToDate(dst, dstBackend, key, srcInfoFile, srcData, opts.Checksum)
+		if err != nil {
+			return false, err
+		}
+		if upToDate {
+			return false, nil
+		}
+	}
+	if opts.DryRun {
+		return true, nil
+	}
+	info := Info{}
+	err = json.Unmarshal(srcInfoFile, &info)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse info file (%s)", err)
+	}
+	// Writers holding a generation of the key in dst must see it change
+	info, err = nextGeneration(dstBackend, dst.storedPath(key), info)
+	if err != nil {
+		return false, fmt.Errorf("failed to read destination info file (%s)", err)
+	}
+	infoJSON, err := json.Marshal(&info)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode info file (%s)", err)
+	}
+	oldChecksum, err := dst.auditChecksum(dstBackend, key)
+	if err != nil {
+		return false, err
+	}
+	newChecksum := ""
+	if dst.Audit != nil {
+		value, err := base64.StdEncoding.DecodeString(string(srcData))
+		if err != nil {
+			return false, fmt.Errorf("failed to decode data file (%s)", err)
+		}
+		newChecksum = checksum(value)
+	}
+	err = dstBackend.WriteFile(dst.keyFile(key, "data"), srcData)
+	if err != nil {
+		return false, fmt.Errorf("failed to write data file (%s)", err)
+	}
+	err = dstBackend.WriteFile(dst.keyFile(key, "info"), infoJSON)
+	if err != nil {
+		return false, fmt.Errorf("failed to write info file (%s)", err)
+	}
+	return true, dst.audit(context.Background(), AuditRecord{Operation: "Put", Path: key, OldChecksum: oldChecksum, NewChecksum: newChecksum})
+}
+
+func isUpToDate(dst KV, dstBackend backends.KvBackend, key string, srcInfoFile []byte, srcData []byte, checksum bool) (bool, error) {
+	found, err := dstBackend.Exist(dst.keyFile(key, "data"))
+	if err != nil || !found {
+		return false, err
+	}
+	if checksum {
+		dstData, err := dstBackend.ReadFile(dst.keyFile(key, "data"))
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(srcData, dstData), nil
+	}
+	srcInfo := Info{}
+	err = json.Unmarshal(srcInfoFile, &srcInfo)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse info file (%s)", err)
+	}
+	dstInfo, err := getInfo(dstBackend, dst.storedPath(key))
+	if err != nil {
+		// Unreadable info in the destination, copying fixes it
+		return false, nil
+	}
+	return !dstInfo.UpdatedAt.Before(srcInfo.UpdatedAt), nil
+}
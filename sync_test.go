@@ -0,0 +1,160 @@
+package multikv
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcelocarlos/multikv/backends/local"
+)
+
+func newTestKV(t *testing.T) (KV, func()) {
+	baseDir, err := ioutil.TempDir("", "multikv-test-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := local.NewLocalBackend(baseDir)
+	if err != nil {
+		os.RemoveAll(baseDir)
+		t.Fatal(err)
+	}
+	return KV{Backend: backend}, func() { os.RemoveAll(baseDir) }
+}
+
+func TestSync(t *testing.T) {
+	src, cleanupSrc := newTestKV(t)
+	defer cleanupSrc()
+	dst, cleanupDst := newTestKV(t)
+	defer cleanupDst()
+	for _, path := range []string{"a", "a/b", "c/d"} {
+		err := src.Put(path, []byte(path))
+		if err != nil {
+			t.Fatalf("TestSync: Put should have succeeded (%s)", err)
+		}
+	}
+
+	result, err := Sync(src, dst, SyncOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatalf("TestSync: should not have failed (%s)", err)
+	}
+	expected := []string{"a", "a/b", "c/d"}
+	if !reflect.DeepEqual(result.Copied, expected) {
+		t.Errorf("TestSync: copied keys did not match. Expected: %v; Found: %v", expected, result.Copied)
+	}
+	srcInfo, _ := src.GetInfo("a/b")
+	dstInfo, err := dst.GetInfo("a/b")
+	if err != nil {
+		t.Errorf("TestSync: GetInfo should not have failed (%s)", err)
+	}
+	if !dstInfo.CreatedAt.Equal(srcInfo.CreatedAt) {
+		t.Errorf("TestSync: CreatedAt should have been preserved (expected %s got %s)", srcInfo.CreatedAt, dstInfo.CreatedAt)
+	}
+	data, err := dst.Get("c/d")
+	if err != nil || string(data) != "c/d" {
+		t.Errorf("TestSync: copied value is different from original (got '%s', %v)", data, err)
+	}
+}
+
+func TestSync_Generation(t *testing.T) {
+	src, cleanupSrc := newTestKV(t)
+	defer cleanupSrc()
+	dst, cleanupDst := newTestKV(t)
+	defer cleanupDst()
+	err := src.Put("a", []byte("src"))
+	if err != nil {
+		t.Fatalf("TestSync_Generation: Put should have succeeded (%s)", err)
+	}
+	for _, value := range []string{"v1", "v2", "v3"} {
+		err = dst.Put("a", []byte(value))
+		if err != nil {
+			t.Fatalf("TestSync_Generation: Put should have succeeded (%s)", err)
+		}
+	}
+	err = src.Put("b", []byte("src"))
+	if err != nil {
+		t.Fatalf("TestSync_Generation: Put should have succeeded (%s)", err)
+	}
+
+	_, err = Sync(src, dst, SyncOptions{})
+	if err != nil {
+		t.Fatalf("TestSync_Generation: should not have failed (%s)", err)
+	}
+	for key, expected := range map[string]int64{"a": 4, "b": 1} {
+		info, err := dst.GetInfo(key)
+		if err != nil {
+			t.Fatalf("TestSync_Generation: GetInfo should not have failed (%s)", err)
+		}
+		if info.Generation != expected {
+			t.Errorf("TestSync_Generation: expected generation %d for '%s', got %d", expected, key, info.Generation)
+		}
+	}
+}
+
+func TestSync_Incremental(t *testing.T) {
+	src, cleanupSrc := newTestKV(t)
+	defer cleanupSrc()
+	dst, cleanupDst := newTestKV(t)
+	defer cleanupDst()
+	for _, path := range []string{"a", "b"} {
+		err := src.Put(path, []byte(path))
+		if err != nil {
+			t.Fatalf("TestSync_Incremental: Put should have succeeded (%s)", err)
+		}
+	}
+	_, err := Sync(src, dst, SyncOptions{})
+	if err != nil {
+		t.Fatalf("TestSync_Incremental: should not have failed (%s)", err)
+	}
+	time.Sleep(time.Millisecond)
+	err = src.Put("b", []byte("updated"))
+	if err != nil {
+		t.Fatalf("TestSync_Incremental: Put should have succeeded (%s)", err)
+	}
+
+	for _, checksum := range []bool{false, true} {
+		result, err := Sync(src, dst, SyncOptions{Incremental: true, Checksum: checksum, DryRun: true})
+		if err != nil {
+			t.Fatalf("TestSync_Incremental: should not have failed (%s)", err)
+		}
+		if !reflect.DeepEqual(result.Copied, []string{"b"}) || !reflect.DeepEqual(result.Skipped, []string{"a"}) {
+			t.Errorf("TestSync_Incremental: expected only 'b' to be copied (checksum: %t), got %+v", checksum, result)
+		}
+	}
+	data, err := dst.Get("b")
+	if err != nil || string(data) != "b" {
+		t.Errorf("TestSync_Incremental: dry-run should not have changed the destination (got '%s', %v)", data, err)
+	}
+}
+
+func TestSync_Delete(t *testing.T) {
+	src, cleanupSrc := newTestKV(t)
+	defer cleanupSrc()
+	dst, cleanupDst := newTestKV(t)
+	defer cleanupDst()
+	err := src.Put("a/b", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestSync_Delete: Put should have succeeded (%s)", err)
+	}
+	err = dst.Put("a", []byte("stale"))
+	if err != nil {
+		t.Fatalf("TestSync_Delete: Put should have succeeded (%s)", err)
+	}
+
+	result, err := Sync(src, dst, SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatalf("TestSync_Delete: should not have failed (%s)", err)
+	}
+	if !reflect.DeepEqual(result.Deleted, []string{"a"}) {
+		t.Errorf("TestSync_Delete: expected 'a' to be deleted, got %v", result.Deleted)
+	}
+	_, err = dst.Get("a")
+	if err == nil {
+		t.Errorf("TestSync_Delete: 'a' should have been removed from the destination")
+	}
+	_, err = dst.Get("a/b")
+	if err != nil {
+		t.Errorf("TestSync_Delete: nested key 'a/b' should have been kept (%s)", err)
+	}
+}
@@ -0,0 +1,115 @@
+package multikv
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	kv, cleanup := newTestKV(t)
+	defer cleanup()
+	exporter := tracetest.NewInMemoryExporter()
+	kv.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	err := kv.PutContext(context.Background(), "test/key", []byte("test"))
+	if err != nil {
+		t.Fatalf("TestTracing: Put should have succeeded (%s)", err)
+	}
+	spans := exporter.GetSpans()
+	// Children end first: the data write, the info read and the info write
+	expected := []string{"multikv.backend.WriteFile", "multikv.backend.ReadFile", "multikv.backend.WriteFile", "multikv.Put"}
+	if len(spans) != len(expected) {
+		t.Fatalf("TestTracing: expected spans %v, got %d spans", expected, len(spans))
+	}
+	put := spans[len(spans)-1]
+	for i, span := range spans {
+		if span.Name != expected[i] {
+			t.Errorf("TestTracing: expected span %s, got %s", expected[i], span.Name)
+		}
+		if i < len(spans)-1 && span.Parent.SpanID() != put.SpanContext.SpanID() {
+			t.Errorf("TestTracing: backend span %s should be a child of the Put span", span.Name)
+		}
+	}
+	attrs := make(map[string]string)
+	for _, kv := range put.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["multikv.path"] != "test/key" || attrs["multikv.bytes"] != "4" || attrs["multikv.backend"] != "local.LocalBackend" {
+		t.Errorf("TestTracing: unexpected Put span attributes %v", attrs)
+	}
+
+	exporter.Reset()
+	_, err = kv.GetContext(context.Background(), "test/missing")
+	if err == nil {
+		t.Fatalf("TestTracing: Get should have failed")
+	}
+	spans = exporter.GetSpans()
+	if len(spans) != 2 || spans[1].Name != "multikv.Get" || spans[1].Status.Code != codes.Error {
+		t.Errorf("TestTracing: expected a failed Get span, got %v", spans)
+	}
+
+	exporter.Reset()
+	err = kv.Copy("test/key", "test/copy")
+	if err != nil {
+		t.Fatalf("TestTracing: Copy should have succeeded (%s)", err)
+	}
+	spans = exporter.GetSpans()
+	copySpan := spans[len(spans)-1]
+	if copySpan.Name != "multikv.Copy" {
+		t.Fatalf("TestTracing: expected a Copy span, got %s", copySpan.Name)
+	}
+	copied := false
+	for _, span := range spans[:len(spans)-1] {
+		if span.Parent.SpanID() != copySpan.SpanContext.SpanID() {
+			t.Errorf("TestTracing: backend span %s should be a child of the Copy span", span.Name)
+		}
+		copied = copied || span.Name == "multikv.backend.CopyFile"
+	}
+	if !copied {
+		t.Errorf("TestTracing: expected the data to be copied server-side")
+	}
+
+	other, cleanupOther := newTestKV(t)
+	defer cleanupOther()
+	other.Tracer = kv.Tracer
+	var export bytes.Buffer
+	err = kv.Export("test", &export)
+	if err != nil {
+		t.Fatalf("TestTracing: Export should have succeeded (%s)", err)
+	}
+	operations := map[string]func() error{
+		"Export":        func() error { return kv.Export("test", ioutil.Discard) },
+		"Import":        func() error { _, err := other.Import(bytes.NewReader(export.Bytes()), ConflictSkip); return err },
+		"Sync":          func() error { _, err := Sync(kv, other, SyncOptions{}); return err },
+		"ListSnapshots": func() error { _, err := kv.ListSnapshots(); return err },
+	}
+	for name, operation := range operations {
+		exporter.Reset()
+		err = operation()
+		if err != nil {
+			t.Fatalf("TestTracing: %s should have succeeded (%s)", name, err)
+		}
+		spans = exporter.GetSpans()
+		if len(spans) < 2 || spans[len(spans)-1].Name != "multikv."+name {
+			t.Fatalf("TestTracing: expected a %s span with backend children, got %v", name, spans)
+		}
+		// Sync records a span in both stores
+		parents := make(map[string]bool)
+		for _, span := range spans {
+			if span.Name == "multikv."+name {
+				parents[span.SpanContext.SpanID().String()] = true
+			}
+		}
+		for _, span := range spans {
+			if span.Name != "multikv."+name && !parents[span.Parent.SpanID().String()] {
+				t.Errorf("TestTracing: backend span %s should be a child of the %s span", span.Name, name)
+			}
+		}
+	}
+}